@@ -0,0 +1,380 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// analyzeOptions are the flags of "analyze" that only some analyses use.
+type analyzeOptions struct {
+	prune string // reach: write the machine without its dead parts here
+}
+
+// analyses are the subcommands of "analyze".
+var analyses = map[string]func(m *machine.Machine, opt analyzeOptions){
+	"merge":     analyzeMerge,
+	"reach":     analyzeReach,
+	"cycles":    analyzeCycles,
+	"empty":     analyzeEmpty,
+	"finite":    analyzeFinite,
+	"universal": analyzeUniversal,
+}
+
+func analyzeCmd(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	var opt analyzeOptions
+	fs.StringVar(&opt.prune, "prune", "", "reach: write the machine without unreachable states and dead transitions to this `file`")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 2 || analyses[args[0]] == nil {
+		names := make([]string, 0, len(analyses))
+		for name := range analyses {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("Usage: go run . analyze <%s> [flags] <rules.txt>\n", strings.Join(names, "|"))
+		fs.PrintDefaults()
+		return
+	}
+	m, err := loadDeterministic(args[1], *strict, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	analyses[args[0]](m, opt)
+}
+
+// equivalentStates partitions the defined states into classes that behave
+// identically: same halting flag, or same direction and, symbol by symbol,
+// the same edge direction into the same class (and, in a dfa, the same
+// accept flag). Merging a class into one state does not change what the
+// machine does on any tape. Only classes with more than one state are
+// returned, each sorted by id.
+func equivalentStates(states []*machine.State) [][]*machine.State {
+
+	var live []*machine.State
+	for _, s := range states {
+		if s.Defined {
+			live = append(live, s)
+		}
+	}
+
+	class := map[*machine.State]int{}
+	signature := func(s *machine.State) string {
+		switch {
+		case s.Accept:
+			return "accept"
+		case s.Reject:
+			return "reject"
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s|%t|", s.Dir, s.Final)
+		// sorted rather than in file order, so equal states sign alike
+		syms := make([]int, 0, len(s.Next))
+		for sym := range s.Next {
+			syms = append(syms, int(sym))
+		}
+		sort.Ints(syms)
+		for _, sym := range syms {
+			e := s.Next[byte(sym)]
+			fmt.Fprintf(&b, "%c%d%d,", sym, e.Dir, class[e.To])
+		}
+		if s.Other != nil {
+			fmt.Fprintf(&b, "*%d%d", s.Other.Dir, class[s.Other.To])
+		}
+		return b.String()
+	}
+
+	// Refine until the number of classes stops growing.
+	for n := 0; ; {
+		ids := map[string]int{}
+		next := map[*machine.State]int{}
+		for _, s := range live {
+			sig := fmt.Sprintf("%d/%s", class[s], signature(s))
+			if _, ok := ids[sig]; !ok {
+				ids[sig] = len(ids)
+			}
+			next[s] = ids[sig]
+		}
+		class = next
+		if len(ids) == n {
+			break
+		}
+		n = len(ids)
+	}
+
+	groups := map[int][]*machine.State{}
+	for _, s := range live {
+		groups[class[s]] = append(groups[class[s]], s)
+	}
+	var out [][]*machine.State
+	for _, g := range groups {
+		if len(g) > 1 {
+			out = append(out, g)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0].ID < out[j][0].ID })
+	return out
+}
+
+func analyzeMerge(m *machine.Machine, _ analyzeOptions) {
+	groups := equivalentStates(m.States)
+	if len(groups) == 0 {
+		fmt.Println("no equivalent states")
+		return
+	}
+	total, saved := 0, 0
+	for _, s := range m.States {
+		if s.Defined {
+			total++
+		}
+	}
+	for _, g := range groups {
+		// keep the start state if it is in the class, else the lowest id
+		keep := g[0]
+		for _, s := range g {
+			if s == m.Start {
+				keep = s
+			}
+		}
+		var others []string
+		for _, s := range g {
+			if s != keep {
+				others = append(others, fmt.Sprint(s.ID))
+			}
+		}
+		saved += len(others)
+		if len(others) == 1 {
+			fmt.Printf("state %s behaves like %d: merge it into %d\n", others[0], keep.ID, keep.ID)
+		} else {
+			fmt.Printf("states %s behave like %d: merge them into %d\n", strings.Join(others, ", "), keep.ID, keep.ID)
+		}
+	}
+	fmt.Printf("merging would shrink the machine from %d to %d states\n", total, total-saved)
+}
+
+// automaton returns a one-way DFA over alphabet accepting what m accepts:
+// a dfa is read off its compiled table, a 2dfa is converted. State 0 is
+// the start; runs that get stuck or reject go to a dead state.
+func automaton(m *machine.Machine, alphabet string) (delta [][]int, accept []bool, err error) {
+	if m.Kind == machine.TwoWay {
+		return toDFA(m, alphabet, 10000)
+	}
+	t := compile(m)
+	index := map[int]int{} // table id, or tabAccept/tabReject for the sinks
+	var queue []int
+	add := func(q int) int {
+		if q == tabStuck {
+			q = tabReject
+		}
+		if i, ok := index[q]; ok {
+			return i
+		}
+		index[q] = len(queue)
+		queue = append(queue, q)
+		return len(queue) - 1
+	}
+	add(t.start)
+	for i := 0; i < len(queue); i++ {
+		q := queue[i]
+		row := make([]int, len(alphabet))
+		for j := 0; j < len(alphabet); j++ {
+			if q < 0 {
+				row[j] = i
+				continue
+			}
+			row[j] = add(int(t.next[q*256+int(alphabet[j])]))
+		}
+		delta = append(delta, row)
+		accept = append(accept, q == tabAccept || q > 0 && t.final[q])
+	}
+	return delta, accept, nil
+}
+
+// shortestWord returns a shortest word leading from state from to a state
+// for which to is true, by breadth-first search.
+func shortestWord(delta [][]int, alphabet string, from int, to func(q int) bool) (string, bool) {
+	type via struct {
+		prev int
+		sym  byte
+	}
+	seen := map[int]via{from: {-1, 0}}
+	for queue := []int{from}; len(queue) > 0; queue = queue[1:] {
+		q := queue[0]
+		if to(q) {
+			var w []byte
+			for ; seen[q].prev >= 0; q = seen[q].prev {
+				w = append(w, seen[q].sym)
+			}
+			for i, j := 0, len(w)-1; i < j; i, j = i+1, j-1 {
+				w[i], w[j] = w[j], w[i]
+			}
+			return string(w), true
+		}
+		for j, p := range delta[q] {
+			if _, ok := seen[p]; !ok {
+				seen[p] = via{q, alphabet[j]}
+				queue = append(queue, p)
+			}
+		}
+	}
+	return "", false
+}
+
+// languageOf loads the DFA for an analysis of the accepted language, or
+// says why there is none.
+func languageOf(m *machine.Machine) (alphabet string, delta [][]int, accept []bool, ok bool) {
+	alphabet, open := m.Alphabet()
+	if open || alphabet == "" {
+		fmt.Println("analyze error: declare the input symbols with an alphabet: header")
+		return "", nil, nil, false
+	}
+	delta, accept, err := automaton(m, alphabet)
+	if err != nil {
+		fmt.Println("analyze error:", err)
+		return "", nil, nil, false
+	}
+	return alphabet, delta, accept, true
+}
+
+func analyzeEmpty(m *machine.Machine, _ analyzeOptions) {
+	alphabet, delta, accept, ok := languageOf(m)
+	if !ok {
+		return
+	}
+	w, found := shortestWord(delta, alphabet, 0, func(q int) bool { return accept[q] })
+	if !found {
+		fmt.Println("the language is empty: no input is accepted")
+		return
+	}
+	fmt.Printf("the language is not empty: a shortest accepted input is %q\n", "#"+w+"#")
+}
+
+func analyzeUniversal(m *machine.Machine, _ analyzeOptions) {
+	alphabet, delta, accept, ok := languageOf(m)
+	if !ok {
+		return
+	}
+	w, found := shortestWord(delta, alphabet, 0, func(q int) bool { return !accept[q] })
+	if !found {
+		fmt.Printf("every input over %q is accepted\n", alphabet)
+		return
+	}
+	tape := "#" + w + "#"
+	res := runSilent(tape, m, machine.Runtime{MaxSteps: 1000000})
+	fmt.Printf("not every input is accepted: a shortest one that is not is %q (%s)\n", tape, res.Outcome)
+}
+
+func analyzeFinite(m *machine.Machine, _ analyzeOptions) {
+	alphabet, delta, accept, ok := languageOf(m)
+	if !ok {
+		return
+	}
+	// useful states are reachable from the start and can still reach an
+	// accepting state; the language is infinite iff they form a cycle
+	n := len(delta)
+	reach := make([]bool, n)
+	reach[0] = true
+	for stack := []int{0}; len(stack) > 0; {
+		q := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range delta[q] {
+			if !reach[p] {
+				reach[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+	useful := make([]bool, n)
+	for changed := true; changed; {
+		changed = false
+		for q := 0; q < n; q++ {
+			if useful[q] || !reach[q] {
+				continue
+			}
+			for _, p := range delta[q] {
+				if accept[q] || useful[p] {
+					useful[q], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	if !useful[0] {
+		fmt.Println("the language is empty, so finite")
+		return
+	}
+
+	// depth-first search over the useful states for a cycle, keeping
+	// the order in which states finish for counting below
+	const (
+		unseen = iota
+		open
+		done
+	)
+	color := make([]int, n)
+	var order []int
+	loop := -1
+	var visit func(q int)
+	visit = func(q int) {
+		color[q] = open
+		for _, p := range delta[q] {
+			if loop >= 0 || !useful[p] {
+				continue
+			}
+			switch color[p] {
+			case open:
+				loop = p
+			case unseen:
+				visit(p)
+			}
+		}
+		color[q] = done
+		order = append(order, q)
+	}
+	visit(0)
+
+	if loop >= 0 {
+		x, _ := shortestWord(delta, alphabet, 0, func(q int) bool { return q == loop })
+		y := ""
+		for j, p := range delta[loop] {
+			if w, ok := shortestWord(delta, alphabet, p, func(q int) bool { return q == loop }); ok && (y == "" || len(w)+1 < len(y)) {
+				y = string(alphabet[j]) + w
+			}
+		}
+		z, _ := shortestWord(delta, alphabet, loop, func(q int) bool { return accept[q] })
+		fmt.Printf("the language is infinite: x=%q y=%q z=%q, and every x y^i z is accepted, e.g.\n", x, y, z)
+		for i := 0; i <= 2; i++ {
+			fmt.Printf("  %s\n", "#"+x+strings.Repeat(y, i)+z+"#")
+		}
+		return
+	}
+
+	// no cycle: count the accepted inputs and find the longest, states
+	// in finishing order so successors come first
+	count := make([]*big.Int, n)
+	longest := make([]int, n) // -1: no accepted input from here
+	for _, q := range order {
+		count[q], longest[q] = new(big.Int), -1
+		if accept[q] {
+			count[q].SetInt64(1)
+			longest[q] = 0
+		}
+		for _, p := range delta[q] {
+			if useful[p] {
+				count[q].Add(count[q], count[p])
+				longest[q] = max(longest[q], longest[p]+1)
+			}
+		}
+	}
+	fmt.Printf("the language is finite: %s accepted inputs, the longest %d symbols long\n", count[0], longest[0])
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"project_twa/pkg/machine"
+)
+
+// runBatch runs m on every tape and prints a row for each: the tape, how
+// the run ended, its steps and why. Tapes with an expected verdict are
+// checked against it, and a row that misses it is marked with !. It
+// reports whether every expectation held.
+func runBatch(w io.Writer, tapes []compareTape, m *machine.Machine, cfg machine.Runtime) bool {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAPE\tRESULT\tSTEPS\tREASON")
+	counts := map[string]int{}
+	checked, failed := 0, 0
+	for _, t := range tapes {
+		var cell, steps, reason string
+		var accepted bool
+		if err := m.CheckTape(t.tape); err != nil {
+			cell, steps, reason = "bad tape", "-", err.Error()
+		} else {
+			res := runSilent(t.tape, m, cfg)
+			cell, steps, reason, accepted = verdictCell(res), fmt.Sprint(res.Steps), res.Reason, res.Accepted
+		}
+		counts[cell]++
+		if t.expect != "" {
+			checked++
+			if accepted != (t.expect == "accept") {
+				failed++
+				cell += "! (expected " + t.expect + ")"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.tape, cell, steps, reason)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "%d tapes: %d accepted, %d rejected", len(tapes), counts["accept"], counts["reject"])
+	if other := len(tapes) - counts["accept"] - counts["reject"]; other > 0 {
+		fmt.Fprintf(w, ", %d other", other)
+	}
+	fmt.Fprintln(w)
+	if checked > 0 {
+		fmt.Fprintf(w, "%d/%d as expected\n", checked-failed, checked)
+	}
+	return failed == 0
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// cutDown is a compiled table cut down to the columns a machine needs:
+// column 0 stands for every byte not in cols, and column k+1 for cols[k].
+type cutDown struct {
+	cols string // the alphabet and '#'
+	open bool   // column 0 may have transitions (a wildcard, no alphabet)
+	next [][]int32
+	move [][]int8
+}
+
+func cutTable(m *machine.Machine, t *table) cutDown {
+	alphabet, open := m.Alphabet()
+	ct := cutDown{cols: alphabet + "#", open: open}
+
+	// column 0 has no transitions, or with an open alphabet whatever the
+	// wildcard does on a byte no state names
+	other := -1
+	if open {
+		named := map[byte]bool{}
+		for _, s := range m.States {
+			if s != nil {
+				for _, sym := range s.Order {
+					named[sym] = true
+				}
+			}
+		}
+		for b := 0; b < 256 && other < 0; b++ {
+			if !named[byte(b)] && strings.IndexByte(ct.cols, byte(b)) < 0 {
+				other = b
+			}
+		}
+	}
+	for q := 0; q < t.n; q++ {
+		next, move := make([]int32, len(ct.cols)+1), make([]int8, len(ct.cols)+1)
+		for k := -1; k < len(ct.cols); k++ {
+			sym := other
+			if k >= 0 {
+				sym = int(ct.cols[k])
+			}
+			if sym >= 0 {
+				next[k+1], move[k+1] = t.next[q*256+sym], t.move[q*256+sym]
+			}
+		}
+		ct.next, ct.move = append(ct.next, next), append(ct.move, move)
+	}
+	return ct
+}
+
+// goAccepter writes Go source for a standalone, table-driven function
+// that accepts what m accepts: the compiled table cut down to one column
+// per symbol the machine knows, the bounds and dfa rules of run, and loop
+// detection, so every call returns. Inputs the tape check would refuse
+// are not accepted.
+func goAccepter(w io.Writer, m *machine.Machine, from, pkg, fn string) error {
+	t := compile(m)
+	tab := strings.ToLower(fn[:1]) + fn[1:] // prefix of the unexported tables
+	ct := cutTable(m, t)
+	cols, open := ct.cols, ct.open
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by \"go run . codegen %s\"; DO NOT EDIT.\n\n", from)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "// %s reports whether the %s in %s accepts input, run on the tape\n// \"#\" + input + \"#\".\n", fn, m.Kind, from)
+	fmt.Fprintf(&b, "func %s(input string) bool {\n", fn)
+	// the tape check: symbols outside the alphabet, '#' with bounce
+	var refuse []string
+	if !open {
+		refuse = append(refuse, fmt.Sprintf("%sColumn[input[i]] == 0", tab))
+	}
+	if m.Bounce {
+		refuse = append(refuse, "input[i] == '#'")
+	}
+	if len(refuse) > 0 {
+		fmt.Fprintf(&b, "\tfor i := 0; i < len(input); i++ {\n\t\tif %s {\n\t\t\treturn false\n\t\t}\n\t}\n", strings.Join(refuse, " || "))
+	}
+	b.WriteString("\ttape := \"#\" + input + \"#\"\n")
+	fmt.Fprintf(&b, "\tseen := make([]bool, %d*len(tape)) // (state, head) pairs: a repeat is a loop\n", t.n)
+	fmt.Fprintf(&b, "\tq, i := %d, 1\n", t.start)
+	b.WriteString("\tfor {\n")
+	if t.bounds == machine.BoundsClamp {
+		b.WriteString("\t\tif i < 0 {\n\t\t\ti = 0\n\t\t} else if i >= len(tape) {\n\t\t\ti = len(tape) - 1\n\t\t}\n")
+	} else {
+		b.WriteString("\t\tif i < 0 || i >= len(tape) {\n\t\t\treturn false // off the tape\n\t\t}\n")
+	}
+	if t.oneWay {
+		fmt.Fprintf(&b, "\t\tif i == len(tape)-1 {\n\t\t\treturn %sFinal[q]\n\t\t}\n", tab)
+	}
+	b.WriteString("\t\tif seen[q*len(tape)+i] {\n\t\t\treturn false\n\t\t}\n\t\tseen[q*len(tape)+i] = true\n")
+	fmt.Fprintf(&b, "\t\tc := %sColumn[tape[i]]\n", tab)
+	fmt.Fprintf(&b, "\t\tswitch next := %sNext[q][c]; next {\n", tab)
+	b.WriteString("\t\tcase 0:\n\t\t\treturn false // no transition\n\t\tcase -1:\n\t\t\treturn true\n\t\tcase -2:\n\t\t\treturn false\n\t\tdefault:\n")
+	fmt.Fprintf(&b, "\t\t\tq, i = int(next), i+int(%sMove[q][c])\n", tab)
+	b.WriteString("\t\t}\n\t}\n}\n\n")
+
+	fmt.Fprintf(&b, "// %sColumn maps a byte to its column in the tables; 0 is any other byte.\n", tab)
+	fmt.Fprintf(&b, "var %sColumn = [256]uint8{", tab)
+	for k := 0; k < len(cols); k++ {
+		fmt.Fprintf(&b, "%q: %d, ", rune(cols[k]), k+1)
+	}
+	b.WriteString("}\n\n")
+
+	fmt.Fprintf(&b, "// %sNext is the next state by state and column: 0 no transition,\n// -1 accept, -2 reject.\n", tab)
+	fmt.Fprintf(&b, "var %sNext = [%d][%d]int32{\n", tab, t.n, len(cols)+1)
+	var moves strings.Builder
+	for q := 0; q < t.n; q++ {
+		b.WriteString("\t{")
+		moves.WriteString("\t{")
+		for k := range ct.next[q] {
+			fmt.Fprintf(&b, "%d, ", ct.next[q][k])
+			fmt.Fprintf(&moves, "%d, ", ct.move[q][k])
+		}
+		b.WriteString("},\n")
+		moves.WriteString("},\n")
+	}
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "// %sMove is the head move by state and column.\n", tab)
+	fmt.Fprintf(&b, "var %sMove = [%d][%d]int8{\n%s}\n", tab, t.n, len(cols)+1, moves.String())
+	if t.oneWay {
+		fmt.Fprintf(&b, "\n// %sFinal tells the accepting states.\n", tab)
+		fmt.Fprintf(&b, "var %sFinal = [%d]bool{", tab, t.n)
+		for q, f := range t.final {
+			if f {
+				fmt.Fprintf(&b, "%d: true, ", q)
+			}
+		}
+		b.WriteString("}\n")
+	}
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// codegenCmd writes a machine as a self-contained Go function, to embed
+// it in a program without the simulator.
+func codegenCmd(args []string) {
+	fs := flag.NewFlagSet("codegen", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	outPath := fs.String("o", "", "write the Go source to this file instead of stdout")
+	pkg := fs.String("package", "main", "package clause of the generated file")
+	fn := fs.String("func", "Accept", "name of the generated function; its tables are named after it")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 || !token.IsIdentifier(*pkg) || !token.IsIdentifier(*fn) {
+		fmt.Println("Usage: go run . codegen [flags] <rules.txt>")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := loadDeterministic(args[0], *strict, os.Stderr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	w := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("codegen error:", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := goAccepter(w, m, args[0], *pkg, *fn); err != nil {
+		fmt.Println("codegen error:", err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"project_twa/pkg/machine"
+)
+
+// compareTape is a column of the comparison: a tape and, if the tapes file
+// gives one, the verdict expected on it.
+type compareTape struct {
+	tape   string
+	expect string // "accept", "reject" or ""
+}
+
+// readTapes reads the tapes to compare on: one "#tape#" per line, with an
+// optional accept or reject after it, so a grade suite works as it is.
+// Blank lines and // comments are skipped.
+func readTapes(path string) ([]compareTape, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tapes []compareTape
+	sc := bufio.NewScanner(f)
+	for ln := 1; sc.Scan(); ln++ {
+		text := strings.TrimSpace(sc.Text())
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" || isConstraint(text) {
+			continue // a grade suite's constraints are for grade
+		}
+		fields := strings.Fields(text)
+		if len(fields) > 2 || len(fields) == 2 && fields[1] != "accept" && fields[1] != "reject" {
+			return nil, fmt.Errorf("%s:%d: want \"#tape#\", optionally followed by accept or reject", path, ln)
+		}
+		tape, err := machine.ParseTape(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, ln, err)
+		}
+		t := compareTape{tape: tape}
+		if len(fields) == 2 {
+			t.expect = fields[1]
+		}
+		tapes = append(tapes, t)
+	}
+	return tapes, sc.Err()
+}
+
+// verdictCell is what a cell of the matrix shows for a run: accept or
+// reject, or how else the run ended.
+func verdictCell(res machine.Result) string {
+	switch res.Outcome {
+	case machine.Accepted:
+		return "accept"
+	case machine.Rejected:
+		return "reject"
+	}
+	return res.Outcome.String()
+}
+
+// compareCmd runs several machines, typically alternative solutions to one
+// exercise, on the same tapes and prints who accepts what as a matrix:
+// a row per machine, a column per tape.
+func compareCmd(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	tapesPath := fs.String("tapes", "", "`file` of tapes to run: \"#tape#\" lines, optionally with the expected accept|reject")
+	csvPath := fs.String("csv", "", "also write the matrix as CSV to this `file`")
+	maxSteps := fs.Int("max-steps", 10000, "step bound for each run")
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules files as errors")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) == 0 || *tapesPath == "" {
+		fmt.Println("Usage: go run . compare --tapes <tests.txt> [flags] <rules.txt>...")
+		fs.PrintDefaults()
+		return
+	}
+	tapes, err := readTapes(*tapesPath)
+	if err != nil {
+		fmt.Println("tapes error:", err)
+		os.Exit(2)
+	}
+	ms := make([]*machine.Machine, len(args))
+	for k, path := range args {
+		if ms[k], err = load(path, *strict, os.Stderr); err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			os.Exit(2)
+		}
+	}
+
+	// cells[k][n] is machine k on tape n: an outcome, or why the tape was
+	// refused
+	cfg := machine.Runtime{MaxSteps: *maxSteps}
+	cells := make([][]string, len(ms))
+	accepted := make([][]bool, len(ms))
+	for k, m := range ms {
+		for _, t := range tapes {
+			if err := m.CheckTape(t.tape); err != nil {
+				cells[k], accepted[k] = append(cells[k], "bad tape"), append(accepted[k], false)
+				continue
+			}
+			res := runSilent(t.tape, m, cfg)
+			cells[k], accepted[k] = append(cells[k], verdictCell(res)), append(accepted[k], res.Accepted)
+		}
+	}
+
+	expected := false
+	for _, t := range tapes {
+		expected = expected || t.expect != ""
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "MACHINE")
+	for _, t := range tapes {
+		fmt.Fprintf(tw, "\t%s", t.tape)
+	}
+	if expected {
+		fmt.Fprint(tw, "\tPASSED")
+	}
+	fmt.Fprintln(tw)
+	if expected {
+		fmt.Fprint(tw, "(expected)")
+		for _, t := range tapes {
+			fmt.Fprintf(tw, "\t%s", t.expect)
+		}
+		fmt.Fprintln(tw, "\t")
+	}
+	for k, path := range args {
+		fmt.Fprint(tw, path)
+		passed, checked := 0, 0
+		for n, t := range tapes {
+			cell := cells[k][n]
+			if t.expect != "" {
+				checked++
+				if accepted[k][n] == (t.expect == "accept") {
+					passed++
+				} else {
+					cell += "!"
+				}
+			}
+			fmt.Fprintf(tw, "\t%s", cell)
+		}
+		if expected {
+			fmt.Fprintf(tw, "\t%d/%d", passed, checked)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+
+	split := 0
+	for n := range tapes {
+		for k := range ms {
+			if accepted[k][n] != accepted[0][n] {
+				split++
+				break
+			}
+		}
+	}
+	fmt.Printf("%d machines on %d tapes: they disagree on %d\n", len(ms), len(tapes), split)
+	if expected {
+		fmt.Println("(! marks a verdict other than the expected one)")
+	}
+
+	if *csvPath == "" {
+		return
+	}
+	f, err := os.Create(*csvPath)
+	if err != nil {
+		fmt.Println("csv error:", err)
+		os.Exit(2)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	row := []string{"machine"}
+	for _, t := range tapes {
+		row = append(row, t.tape)
+	}
+	w.Write(row)
+	if expected {
+		row = []string{"(expected)"}
+		for _, t := range tapes {
+			row = append(row, t.expect)
+		}
+		w.Write(row)
+	}
+	for k, path := range args {
+		w.Write(append([]string{path}, cells[k]...))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Println("csv error:", err)
+		os.Exit(2)
+	}
+	fmt.Println("wrote", *csvPath)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// config is a configuration of a run: a state and a head position.
+type config struct{ state, head int }
+
+// configGraph collects the configurations a run explores and the steps
+// between them, up to budget configurations, for --config-dot. A step to
+// a configuration already explored is kept as an edge but not followed
+// again: that is where the search deduplicates.
+type configGraph struct {
+	budget int
+	tape   string
+	nodes  map[config]int // configuration -> node number, in order found
+	order  []config
+	edges  []configEdge
+	over   map[config]bool // configurations past the budget
+	end    int             // node the last step led to
+}
+
+type configEdge struct {
+	from, to int
+	label    string
+	repeat   bool // to a configuration already explored
+}
+
+func newConfigGraph(budget int) *configGraph {
+	return &configGraph{budget: budget, nodes: map[config]int{}, over: map[config]bool{}}
+}
+
+// node returns the number of c, adding it if the budget allows; ok is
+// false when c is past the budget.
+func (g *configGraph) node(c config) (n int, seen, ok bool) {
+	if n, seen := g.nodes[c]; seen {
+		return n, true, true
+	}
+	if len(g.order) == g.budget {
+		g.over[c] = true
+		return 0, false, false
+	}
+	g.nodes[c] = len(g.order)
+	g.order = append(g.order, c)
+	return g.nodes[c], false, true
+}
+
+func (g *configGraph) step(ev machine.StepEvent) {
+	g.tape = ev.Cells
+	from, _, ok := g.node(config{ev.State, ev.Head})
+	if !ok {
+		return
+	}
+	to, seen, ok := g.node(config{ev.Next, ev.NewHead})
+	if !ok {
+		return
+	}
+	label := ev.Read
+	if ev.Status == machine.Continue {
+		label += "/" + ev.Move.String()
+	}
+	g.edges = append(g.edges, configEdge{from, to, label, seen})
+	g.end = to
+}
+
+// write writes the graph as DOT, marking the configuration the run ended
+// in with its outcome.
+func (g *configGraph) write(path string, res machine.Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, "digraph configurations {")
+	fmt.Fprintln(f, `  rankdir=TB; node [shape=box, fontname="Courier"];`)
+	for n, c := range g.order {
+		lbl := fmt.Sprintf("state %d, head %d\n%s", c.state, c.head, machine.HighlightIndex(g.tape, c.head))
+		attrs := ""
+		if n == g.end && len(g.over) == 0 {
+			lbl += "\n" + res.Outcome.String()
+			attrs = `, color="red"`
+			if res.Accepted {
+				attrs = `, color="green", peripheries=2`
+			}
+		}
+		fmt.Fprintf(f, "  c%d [label=%s%s];\n", n, dotQuote(lbl), attrs)
+	}
+	for _, e := range g.edges {
+		if e.repeat {
+			fmt.Fprintf(f, "  c%d -> c%d [label=%s, style=dashed, color=gray];\n", e.from, e.to, dotQuote(e.label+" (seen: not explored again)"))
+			continue
+		}
+		fmt.Fprintf(f, "  c%d -> c%d [label=%s];\n", e.from, e.to, dotQuote(e.label))
+	}
+	if len(g.over) > 0 {
+		fmt.Fprintf(f, "  more [label=%s, shape=note, style=dashed];\n", dotQuote(fmt.Sprintf("%d more configurations\n(budget %d)", len(g.over), g.budget)))
+		if len(g.order) > 0 {
+			fmt.Fprintf(f, "  c%d -> more [style=dashed];\n", len(g.order)-1)
+		}
+	}
+	if len(g.order) == 0 {
+		fmt.Fprintf(f, "  none [label=%s, shape=note];\n", dotQuote("no steps: "+strings.TrimSpace(res.Reason)))
+	}
+	fmt.Fprintln(f, "}")
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// constraint is a limit a grade suite puts on submissions, written as a
+// "key: value" line among the tapes:
+//
+//	max-states: 6
+//	kind: dfa 2dfa
+//	actions: right accept reject
+//	forbid-symbols: *
+type constraint struct {
+	line  int
+	text  string                            // the line as written
+	check func(m *machine.Machine) []string // the violations, if any
+}
+
+// constraintActions are the actions an actions: line may allow.
+var constraintActions = []string{"left", "right", "accept", "reject", "wildcard", "epsilon"}
+
+// isConstraint reports whether a suite line is a constraint and not a
+// tape: tapes start with their '#' endmarker.
+func isConstraint(text string) bool {
+	return !strings.HasPrefix(text, "#") && strings.Contains(text, ":")
+}
+
+// parseConstraint reads a constraint line.
+func parseConstraint(ln int, text string) (constraint, error) {
+	key, value, _ := strings.Cut(text, ":")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	words := strings.Fields(value)
+	c := constraint{line: ln, text: key + ": " + value}
+	if len(words) == 0 {
+		return c, fmt.Errorf("%s: needs a value", key)
+	}
+	switch key {
+	case "max-states":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return c, fmt.Errorf("max-states: want a positive number, got %q", value)
+		}
+		c.check = func(m *machine.Machine) []string {
+			if got := len(definedStates(m)); got > n {
+				return []string{fmt.Sprintf("the submission has %d states", got)}
+			}
+			return nil
+		}
+	case "kind":
+		for _, w := range words {
+			if !slices.Contains([]string{"2dfa", "dfa", "2nfa", "nfa"}, w) {
+				return c, fmt.Errorf("kind: want 2dfa, dfa, 2nfa or nfa, got %q", w)
+			}
+		}
+		c.check = func(m *machine.Machine) []string {
+			for _, w := range words {
+				if w == m.KindName() {
+					return nil
+				}
+			}
+			return []string{fmt.Sprintf("the submission is a %s", m.KindName())}
+		}
+	case "actions":
+		allowed := map[string]bool{}
+		for _, w := range words {
+			if !slices.Contains(constraintActions, w) {
+				return c, fmt.Errorf("actions: unknown action %q (actions: %s)", w, strings.Join(constraintActions, ", "))
+			}
+			allowed[w] = true
+		}
+		c.check = func(m *machine.Machine) []string {
+			var out []string
+			for _, a := range constraintActions {
+				if allowed[a] {
+					continue
+				}
+				if ids := statesUsing(m, a); len(ids) > 0 {
+					out = append(out, fmt.Sprintf("%s is used by %s", a, statesPhrase(ids)))
+				}
+			}
+			return out
+		}
+	case "forbid-symbols":
+		syms := strings.Join(words, "")
+		c.check = func(m *machine.Machine) []string {
+			var out []string
+			for _, sym := range []byte(syms) {
+				var ids []int
+				for _, s := range definedStates(m) {
+					_, ok := s.Next[sym]
+					if ok || sym == '*' && s.Other != nil && !s.Other.Implicit {
+						ids = append(ids, s.ID)
+					}
+				}
+				if len(ids) > 0 {
+					out = append(out, fmt.Sprintf("%q is read by %s", sym, statesPhrase(ids)))
+				}
+			}
+			return out
+		}
+	default:
+		return c, fmt.Errorf("unknown constraint %q (constraints: max-states, kind, actions, forbid-symbols)", key)
+	}
+	return c, nil
+}
+
+// definedStates are the states written in the rules, not added by an
+// on-missing policy.
+func definedStates(m *machine.Machine) []*machine.State {
+	var out []*machine.State
+	for _, s := range m.States {
+		if s != nil && s.Defined && !s.Implicit {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// statesUsing lists the states that use an action of constraintActions.
+func statesUsing(m *machine.Machine, action string) []int {
+	var ids []int
+	for _, s := range definedStates(m) {
+		edges := explicitEdges(s)
+		moves := func(d machine.Move) bool {
+			for _, e := range edges {
+				if e.Dir == d || e.Dir == 0 && s.Dir == d {
+					return true
+				}
+			}
+			return false
+		}
+		var uses bool
+		switch action {
+		case "left":
+			uses = moves(machine.L)
+		case "right":
+			uses = moves(machine.R)
+		case "accept":
+			uses = s.Accept || s.Final
+		case "reject":
+			uses = s.Reject
+		case "wildcard":
+			uses = s.Other != nil && !s.Other.Implicit
+		case "epsilon":
+			uses = len(s.Eps) > 0
+		}
+		if uses {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
+// explicitEdges lists the transitions written on a state's line, leaving
+// out epsilon moves and what an on-missing policy added.
+func explicitEdges(s *machine.State) []machine.Edge {
+	var out []machine.Edge
+	for _, sym := range s.Order {
+		out = append(out, s.EdgesOn(sym)...)
+	}
+	if s.Other != nil && !s.Other.Implicit {
+		out = append(append(out, *s.Other), s.OtherAlts...)
+	}
+	return out
+}
+
+// statesPhrase names states by id: "state 3", "states 1, 2 and 4".
+func statesPhrase(ids []int) string {
+	sort.Ints(ids)
+	s := make([]string, len(ids))
+	for k, id := range ids {
+		s[k] = strconv.Itoa(id)
+	}
+	if len(s) == 1 {
+		return "state " + s[0]
+	}
+	return "states " + strings.Join(s[:len(s)-1], ", ") + " and " + s[len(s)-1]
+}
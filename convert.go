@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// Outcomes of crossing a tape prefix, beside leaving it to the right in
+// some state (a positive id).
+const (
+	crossAccept = -1
+	crossReject = -2 // rejected, stuck, off the tape or looping
+)
+
+// crossCell follows the machine while the head is on one cell holding c,
+// having come in in state q. left tells what happens when the head moves
+// off the cell to the left in some state: the prefix there decides, or
+// sends the head back in a state. It returns crossAccept, crossReject, or
+// the state the head leaves to the right in.
+func crossCell(m *machine.Machine, c byte, q int, left func(p int) int) int {
+	seen := map[int]bool{}
+	for {
+		if seen[q] {
+			return crossReject // back on this cell in the same state: a loop
+		}
+		seen[q] = true
+		e, err := m.States[q].EdgeOn(c)
+		if err != nil || e.To == nil {
+			return crossReject
+		}
+		switch {
+		case e.To.Accept:
+			return crossAccept
+		case e.To.Reject:
+			return crossReject
+		case e.Move() == machine.R:
+			return e.To.ID
+		}
+		if q = left(e.To.ID); q < 0 {
+			return q
+		}
+	}
+}
+
+// crossing summarizes what a 2dfa does on a tape prefix "#w": how the run
+// from the start leaves it (init), and for each state, how a run entering
+// its last cell from the right in that state leaves it (back).
+// Two prefixes with the same crossing are indistinguishable to the
+// machine, so crossings are the states of an equivalent one-way DFA
+// (Shepherdson's construction).
+type crossing struct {
+	init int
+	back []int // by state id
+}
+
+func (x crossing) key() string {
+	if x.init < 0 {
+		return fmt.Sprint(x.init) // decided: the rest of the input does not matter
+	}
+	return fmt.Sprint(x.init, x.back)
+}
+
+// toDFA converts a 2dfa into an equivalent one-way DFA over alphabet,
+// giving up past limit states. The result's state 0 is the start; accept
+// tells which states accept at the end of the input.
+func toDFA(m *machine.Machine, alphabet string, limit int) (delta [][]int, accept []bool, err error) {
+	n := len(m.States)
+	clamp := m.OnBounds == machine.BoundsClamp
+	// moving off the left end rejects, or with clamp keeps the head there
+	offLeft := func(p int) int {
+		if clamp {
+			return p
+		}
+		return crossReject
+	}
+	first := crossing{init: m.Start.ID, back: make([]int, n)}
+	for p := 1; p < n; p++ {
+		first.back[p] = crossCell(m, '#', p, offLeft)
+	}
+
+	index := map[string]int{}
+	var queue []crossing
+	add := func(x crossing) int {
+		k := x.key()
+		if i, ok := index[k]; ok {
+			return i
+		}
+		index[k] = len(queue)
+		queue = append(queue, x)
+		return len(queue) - 1
+	}
+	add(first)
+	for i := 0; i < len(queue); i++ {
+		if len(queue) > limit {
+			return nil, nil, fmt.Errorf("more than %d DFA states", limit)
+		}
+		x := queue[i]
+		row := make([]int, len(alphabet))
+		for j := 0; j < len(alphabet); j++ {
+			if x.init < 0 {
+				row[j] = i
+				continue
+			}
+			y := crossing{back: make([]int, n)}
+			for p := 1; p < n; p++ {
+				y.back[p] = crossCell(m, alphabet[j], p, func(p int) int { return x.back[p] })
+			}
+			y.init = y.back[x.init]
+			row[j] = add(y)
+		}
+		delta = append(delta, row)
+	}
+	for _, x := range queue {
+		// v > 0: the head is on the right endmarker in state v. Moving
+		// right from there leaves the tape, or with clamp comes back.
+		v := x.init
+		seen := map[int]bool{}
+		for v > 0 && !seen[v] {
+			seen[v] = true
+			v = crossCell(m, '#', v, func(p int) int { return x.back[p] })
+			if !clamp {
+				break
+			}
+		}
+		accept = append(accept, v == crossAccept)
+	}
+	return delta, accept, nil
+}
+
+// writeDFA writes a converted machine as kind: dfa rules. States that
+// reject whatever follows become halting reject lines.
+func writeDFA(w io.Writer, from string, alphabet string, delta [][]int, accept []bool) {
+	dead := func(q int) bool {
+		if accept[q] {
+			return false
+		}
+		for _, t := range delta[q] {
+			if t != q {
+				return false
+			}
+		}
+		return true
+	}
+	fmt.Fprintf(w, "// One-way DFA converted from %s; it accepts the same inputs.\n", from)
+	fmt.Fprintln(w, "kind: dfa")
+	fmt.Fprintf(w, "alphabet: %s\n", alphabet)
+	for q, row := range delta {
+		if dead(q) {
+			fmt.Fprintf(w, "%d] reject\n", q+1)
+			continue
+		}
+		var b strings.Builder
+		for j, t := range row {
+			fmt.Fprintf(&b, " (%c,%d)", alphabet[j], t+1)
+		}
+		fmt.Fprintf(w, "%d]%s\n", q+1, b.String())
+		if accept[q] {
+			fmt.Fprintf(w, "%d] accept\n", q+1)
+		}
+	}
+}
+
+// convertCmd turns a 2dfa into an equivalent dfa in the rules format, to
+// run, analyze and draw like any other machine.
+func convertCmd(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	outPath := fs.String("o", "", "write the rules to this file instead of stdout")
+	limit := fs.Int("max-states", 10000, "give up when the DFA grows past this many states")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: go run . convert [flags] <rules.txt>")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := loadDeterministic(args[0], *strict, os.Stderr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if m.Kind == machine.OneWay {
+		fmt.Println("convert error: the machine is already a dfa")
+		return
+	}
+	alphabet, open := m.Alphabet()
+	if open || alphabet == "" {
+		fmt.Println("convert error: declare the input symbols with an alphabet: header")
+		return
+	}
+
+	delta, accept, err := toDFA(m, alphabet, *limit)
+	if err != nil {
+		fmt.Println("convert error:", err)
+		return
+	}
+	w := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("convert error:", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	writeDFA(w, args[0], alphabet, delta, accept)
+}
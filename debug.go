@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// debugScript is what a --debug file asks of a run: conditions to stop
+// it at and fields to watch.
+type debugScript struct {
+	breaks  []debugBreak
+	watches []string          // field names, in file order
+	last    map[string]string // each watched field's value at the last step
+}
+
+type debugBreak struct {
+	src   string
+	match func(ev machine.StepEvent) bool
+}
+
+// readDebugScript reads a --debug file: one entry per line,
+//
+//	break: head==0 && state==4
+//	watch: head
+//
+// where a break condition is as in query, and a watch names a step field.
+// Blank lines and // comments are skipped.
+func readDebugScript(path string) (*debugScript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &debugScript{last: map[string]string{}}
+	sc := bufio.NewScanner(f)
+	for ln := 1; sc.Scan(); ln++ {
+		text := sc.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		kind, src, ok := strings.Cut(text, ":")
+		src = strings.TrimSpace(src)
+		switch kind = strings.TrimSpace(kind); {
+		case ok && kind == "break":
+			match, err := parseCond(src)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, ln, err)
+			}
+			d.breaks = append(d.breaks, debugBreak{src: src, match: match})
+		case ok && kind == "watch":
+			name := strings.ToLower(src)
+			if _, ok := stepFields[name]; !ok {
+				return nil, fmt.Errorf("%s:%d: cannot watch %q: not a step field (see query)", path, ln, src)
+			}
+			d.watches = append(d.watches, name)
+		default:
+			return nil, fmt.Errorf("%s:%d: want \"break: <condition>\" or \"watch: <field>\"", path, ln)
+		}
+	}
+	return d, sc.Err()
+}
+
+// step checks a step against the script. It returns a note for every
+// watched field whose value changed, and the first break condition the
+// step meets, or "".
+func (d *debugScript) step(ev machine.StepEvent) (notes []string, hit string) {
+	for _, name := range d.watches {
+		num, text := stepFields[name](ev)
+		if !textFields[name] {
+			text = strconv.Itoa(num)
+		}
+		if old, ok := d.last[name]; !ok || old != text {
+			if ok {
+				notes = append(notes, fmt.Sprintf("watch %s: %s -> %s at step %d", name, old, text, ev.Step))
+			} else {
+				notes = append(notes, fmt.Sprintf("watch %s: %s at step %d", name, text, ev.Step))
+			}
+			d.last[name] = text
+		}
+	}
+	for _, b := range d.breaks {
+		if b.match(ev) {
+			return notes, b.src
+		}
+	}
+	return notes, ""
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// closure is the set of states an nfa branch can be in from the states of
+// set, by epsilon moves: sorted by id, reject states left out, as a branch
+// that enters one ends.
+func closure(set []*machine.State) []*machine.State {
+	seen := map[*machine.State]bool{}
+	var out []*machine.State
+	for todo := set; len(todo) > 0; {
+		s := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if s == nil || s.Reject || seen[s] {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+		for _, e := range s.Eps {
+			todo = append(todo, e.To)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// subsetKey names a set of states by their ids: "1,4,7".
+func subsetKey(set []*machine.State) string {
+	ids := make([]string, len(set))
+	for k, s := range set {
+		ids[k] = strconv.Itoa(s.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// determinize builds a dfa for the one-way nfa m over alphabet by the
+// subset construction: each dfa state is the set of nfa states the
+// branches can be in, the start the closure of the nfa's start. The empty
+// set, if reached, becomes a reject state. It stops with an error past
+// maxStates dfa states. subsets[id] is the set dfa state id stands for.
+func determinize(m *machine.Machine, alphabet string, maxStates int) (dfa *machine.Machine, subsets [][]*machine.State, err error) {
+	states := []*machine.State{nil}
+	subsets = [][]*machine.State{nil}
+	ids := map[string]*machine.State{}
+	add := func(set []*machine.State) (*machine.State, error) {
+		key := subsetKey(set)
+		if s, ok := ids[key]; ok {
+			return s, nil
+		}
+		if len(states) > maxStates {
+			return nil, fmt.Errorf("more than %d dfa states (raise --max-states)", maxStates)
+		}
+		s := &machine.State{ID: len(states), Dir: machine.R, Defined: true, Reject: len(set) == 0}
+		for _, q := range set {
+			s.Final = s.Final || q.Final
+		}
+		ids[key] = s
+		states, subsets = append(states, s), append(subsets, set)
+		return s, nil
+	}
+
+	if _, err := add(closure([]*machine.State{m.Start})); err != nil {
+		return nil, nil, err
+	}
+	for id := 1; id < len(states); id++ {
+		if states[id].Reject {
+			continue
+		}
+		for k := 0; k < len(alphabet); k++ {
+			var next []*machine.State
+			for _, q := range subsets[id] {
+				for _, e := range q.EdgesOn(alphabet[k]) {
+					next = append(next, e.To)
+				}
+			}
+			to, err := add(closure(next))
+			if err != nil {
+				return nil, nil, err
+			}
+			states[id].SetEdge(alphabet[k], machine.Edge{To: to})
+		}
+	}
+	return machine.NewMachine(machine.OneWay, states, states[1], machine.WithAlphabet(alphabet)), subsets, nil
+}
+
+// determinizeCmd writes a dfa equivalent to a one-way nfa and reports how
+// many states the subset construction took.
+func determinizeCmd(args []string) {
+	fs := flag.NewFlagSet("determinize", flag.ContinueOnError)
+	alphabet := fs.String("alphabet", "", "input symbols (default: the nfa's)")
+	outPath := fs.String("o", "", "write the rules to this file instead of stdout")
+	maxStates := fs.Int("max-states", 10000, "give up past this many dfa states")
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: go run . determinize [flags] <rules.txt>")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := load(args[0], *strict, os.Stderr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if m.Kind != machine.OneWay {
+		fmt.Printf("determinize error: %s is a %s; the subset construction needs a one-way machine (nfa or dfa)\n", args[0], m.KindName())
+		os.Exit(2)
+	}
+	alpha, open := m.Alphabet()
+	if *alphabet != "" {
+		alpha = *alphabet
+	} else if open {
+		fmt.Println("determinize error: a wildcard reads any symbol; give the symbols with --alphabet")
+		os.Exit(2)
+	}
+	if alpha == "" {
+		fmt.Println("determinize error: need at least one input symbol")
+		os.Exit(2)
+	}
+	dfa, subsets, err := determinize(m, alpha, *maxStates)
+	if err != nil {
+		fmt.Println("determinize error:", err)
+		os.Exit(2)
+	}
+
+	w, notes := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("output error:", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		w, notes = f, os.Stdout
+	}
+	keep := map[*machine.State]bool{}
+	for _, s := range dfa.States[1:] {
+		keep[s] = true
+	}
+	fmt.Fprintf(w, "// Deterministic version of %s (subset construction).\n", args[0])
+	for id, set := range subsets[1:] {
+		fmt.Fprintf(w, "// %d = {%s}\n", id+1, subsetKey(set))
+	}
+	writeRules(w, dfa, keep, func(byte) bool { return true })
+
+	if *outPath != "" {
+		fmt.Fprintln(notes, "wrote", *outPath)
+	}
+	n := len(definedStates(m))
+	fmt.Fprintf(notes, "%s: %d states; dfa: %d states (%.1fx; at most 2^%d)\n", m.KindName(), n, len(dfa.States)-1, float64(len(dfa.States)-1)/float64(n), n)
+}
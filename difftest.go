@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// difftestCmd runs every word up to --max-len through each run path and
+// reports any disagreement: the interpreted engine against the compiled
+// table (outcome and step count), and for a 2dfa, its verdict against the
+// converted dfa's. The machines are the given rules files, or random ones.
+func difftestCmd(args []string) {
+	fs := flag.NewFlagSet("difftest", flag.ContinueOnError)
+	seeds := fs.Int("seeds", 200, "random machines to try when no rules files are given")
+	seed := fs.Int64("seed", 1, "seed of the first random machine")
+	states := fs.Int("states", 5, "working states of the random machines")
+	alphabet := fs.String("alphabet", "ab", "input symbols of the random machines")
+	maxLen := fs.Int("max-len", 6, "longest input to try")
+	maxSteps := fs.Int("max-steps", 10000, "step bound for each run")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if *maxLen < 0 {
+		fmt.Println("Usage: go run . difftest [flags] [rules.txt...]")
+		fs.PrintDefaults()
+		return
+	}
+
+	type subject struct {
+		name string
+		m    *machine.Machine
+	}
+	var corpus []subject
+	for _, path := range args {
+		m, err := loadDeterministic(path, false, io.Discard)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			os.Exit(1)
+		}
+		corpus = append(corpus, subject{path, m})
+	}
+	if len(args) == 0 {
+		for k := int64(0); k < int64(*seeds); k++ {
+			s := *seed + k
+			// cycle through 2dfa v1, 2dfa v2 and dfa, and the on-bounds policies
+			spec := randomSpec{states: *states, alphabet: *alphabet, version: 1 + int(s%3)%2, missing: 0.1, bounds: machine.BoundsPolicy(s / 3 % 3)}
+			if s%3 == 2 {
+				spec.kind = machine.OneWay
+			}
+			var buf bytes.Buffer
+			randomRules(&buf, rand.New(rand.NewSource(s)), spec, s)
+			m, err := loadFrom(&buf, false, io.Discard)
+			if err != nil {
+				fmt.Printf("random seed %d: %v\n", s, err)
+				os.Exit(1)
+			}
+			corpus = append(corpus, subject{fmt.Sprintf("random seed %d (%s)", s, spec.kind), m})
+		}
+	}
+
+	cfg := machine.Runtime{MaxSteps: *maxSteps}
+	runs, bad := 0, 0
+	for _, c := range corpus {
+		alpha, _ := c.m.Alphabet()
+		if alpha == "" {
+			continue
+		}
+		t := compile(c.m)
+		var delta [][]int
+		var accept []bool
+		if c.m.Kind == machine.TwoWay {
+			delta, accept, _ = toDFA(c.m, alpha, 10000) // nil past the limit: skipped
+		}
+		machine.ForEachWord(alpha, *maxLen, func(w string) bool {
+			tape := "#" + w + "#"
+			runs++
+			res := runSilent(tape, c.m, cfg)
+			if o, n := t.run(machine.NewByteTape(tape), *maxSteps); o != res.Outcome || n != res.Steps {
+				bad++
+				fmt.Printf("%s: %s: interpreted %s after %d steps, table %s after %d\n", c.name, tape, res.Outcome, res.Steps, o, n)
+			}
+			if delta != nil {
+				q := 0
+				for k := 0; k < len(w); k++ {
+					q = delta[q][strings.IndexByte(alpha, w[k])]
+				}
+				if accept[q] != res.Accepted {
+					bad++
+					fmt.Printf("%s: %s: interpreted %s, converted dfa accepts: %t\n", c.name, tape, res.Outcome, accept[q])
+				}
+			}
+			return true
+		})
+	}
+	fmt.Printf("%d machines, %d runs, %d disagreements\n", len(corpus), runs, bad)
+	if bad > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import "project_twa/pkg/machine"
+
+// Entries of a compiled table beside a target state id.
+const (
+	tabStuck  = 0 // no transition
+	tabAccept = -1
+	tabReject = -2
+)
+
+// table is a machine compiled for fast runs: the next state and move for
+// every state and byte, so a step is two slice lookups instead of map
+// lookups and edge resolution. It keeps no trace, no trail and no monitor;
+// its outcomes and step counts match run's.
+type table struct {
+	oneWay bool
+	bounds machine.BoundsPolicy
+	start  int
+	n      int     // number of state ids
+	next   []int32 // [state*256+sym]: target id, or tabStuck/tabAccept/tabReject
+	move   []int8  // [state*256+sym]: head move
+	final  []bool  // dfa: accepting at the end of the input
+}
+
+func compile(m *machine.Machine) *table {
+	n := len(m.States)
+	t := &table{
+		oneWay: m.Kind == machine.OneWay,
+		bounds: m.OnBounds,
+		start:  m.Start.ID,
+		n:      n,
+		next:   make([]int32, n*256),
+		move:   make([]int8, n*256),
+		final:  make([]bool, n),
+	}
+	for id, s := range m.States {
+		if s == nil {
+			continue // pruned
+		}
+		t.final[id] = s.Final
+		for sym := 0; sym < 256; sym++ {
+			e, err := s.EdgeOn(byte(sym))
+			k := id*256 + sym
+			switch {
+			case err != nil || e.To == nil:
+				t.next[k] = tabStuck
+			case e.To.Accept:
+				t.next[k] = tabAccept
+			case e.To.Reject:
+				t.next[k] = tabReject
+			default:
+				t.next[k], t.move[k] = int32(e.To.ID), int8(e.Move())
+			}
+		}
+	}
+	return t
+}
+
+// run is the fast counterpart of runSilent: it reports only the outcome
+// and the number of steps taken.
+func (t *table) run(tp machine.Tape, maxSteps int) (machine.Outcome, int) {
+	// seen is a bitset over (state, head) configurations, for the bounds
+	// it was made for: a tape that grows starts it over
+	lo, hi := tp.Bounds()
+	seen := make([]uint64, (t.n*(hi-lo)+63)/64)
+	q, i := t.start, 1
+	for step := 1; ; step++ {
+		if l, h := tp.Bounds(); l != lo || h != hi {
+			lo, hi = l, h
+			seen = make([]uint64, (t.n*(hi-lo)+63)/64)
+		}
+		if i < lo || i >= hi {
+			if t.bounds == machine.BoundsReject {
+				return machine.Rejected, step - 1
+			}
+			return machine.OutOfBounds, step - 1
+		}
+		if t.oneWay && i == hi-1 {
+			if t.final[q] {
+				return machine.Accepted, step - 1
+			}
+			return machine.Rejected, step - 1
+		}
+		if step > maxSteps {
+			return machine.StepLimit, step - 1
+		}
+		c := q*(hi-lo) + i - lo
+		if seen[c/64]&(1<<(c%64)) != 0 {
+			return machine.Looped, step - 1
+		}
+		seen[c/64] |= 1 << (c % 64)
+
+		k := q*256 + int(tp.Read(i))
+		switch nxt := t.next[k]; nxt {
+		case tabStuck:
+			return machine.Stuck, step - 1
+		case tabAccept:
+			return machine.Accepted, step
+		case tabReject:
+			return machine.Rejected, step
+		default:
+			q, i = int(nxt), tp.Move(i, machine.Move(t.move[k]))
+			if t.bounds == machine.BoundsClamp {
+				lo, hi := tp.Bounds()
+				i = min(max(i, lo), hi-1)
+			}
+		}
+	}
+}
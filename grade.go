@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"project_twa/pkg/machine"
+)
+
+// suiteCase is one line of a test suite: a tape and whether it must be
+// accepted.
+type suiteCase struct {
+	line   int
+	tape   string
+	accept bool
+}
+
+// readSuite reads a test suite: one "#tape# accept" or "#tape# reject"
+// per line, and constraint lines like "max-states: 6"; blank lines and //
+// comments are skipped.
+func readSuite(path string) ([]suiteCase, []constraint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var (
+		cases []suiteCase
+		cons  []constraint
+	)
+	sc := bufio.NewScanner(f)
+	for ln := 1; sc.Scan(); ln++ {
+		text := strings.TrimSpace(sc.Text())
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+		if isConstraint(text) {
+			c, err := parseConstraint(ln, text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s:%d: %v", path, ln, err)
+			}
+			cons = append(cons, c)
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || fields[1] != "accept" && fields[1] != "reject" {
+			return nil, nil, fmt.Errorf("%s:%d: want \"#tape# accept\" or \"#tape# reject\"", path, ln)
+		}
+		tape, err := machine.ParseTape(fields[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", path, ln, err)
+		}
+		cases = append(cases, suiteCase{ln, tape, fields[1] == "accept"})
+	}
+	return cases, cons, sc.Err()
+}
+
+// gradeCmd checks a submission against a test suite and, exhaustively up
+// to --max-len, against a reference machine, and prints one pass/fail
+// report with counterexamples. It exits 1 when the submission fails.
+func gradeCmd(args []string) {
+	fs := flag.NewFlagSet("grade", flag.ContinueOnError)
+	refPath := fs.String("reference", "", "reference rules `file` to compare the submission with")
+	subPath := fs.String("submission", "", "submitted rules `file` to grade")
+	suitePath := fs.String("suite", "", "test suite `file`: \"#tape# accept|reject\" lines")
+	alphabet := fs.String("alphabet", "", "input symbols to enumerate (default: the reference's)")
+	maxLen := fs.Int("max-len", 8, "longest input of the exhaustive comparison")
+	maxSteps := fs.Int("max-steps", 10000, "steps a submission run may take")
+	timeout := fs.Duration("timeout", time.Second, "wall-clock time a submission run may take (0: no limit)")
+	show := fs.Int("show", 10, "counterexamples to print for each check")
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules files as errors")
+	junitPath := fs.String("junit", "", "also write the results as JUnit XML to this `file`, a testcase per tape")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 0 || *subPath == "" || *refPath == "" && *suitePath == "" || *maxLen < 0 {
+		fmt.Println("Usage: go run . grade --submission sub.txt [--reference ref.txt] [--suite tests.txt] [flags]")
+		fs.PrintDefaults()
+		return
+	}
+
+	var suites []junitSuite
+	writeReport := func() {
+		if *junitPath == "" {
+			return
+		}
+		if err := writeJUnit(*junitPath, suites); err != nil {
+			fmt.Println("junit error:", err)
+			os.Exit(2)
+		}
+	}
+
+	sub, err := load(*subPath, *strict, os.Stdout)
+	if err != nil {
+		fmt.Printf("%s: %v\nFAIL: the submission does not load\n", *subPath, err)
+		suites = []junitSuite{{Name: *subPath, Tests: 1, Failures: 1, Time: junitSeconds(0), Cases: []junitCase{{
+			Name: "load", ClassName: *subPath, Time: junitSeconds(0),
+			Failure: &junitFailure{Message: "the submission does not load", Type: "load", Text: err.Error()},
+		}}}}
+		writeReport()
+		os.Exit(1)
+	}
+	// submission runs end at the resource limits, as not accepted
+	runSub := func(tape string) machine.Result {
+		cfg := machine.Runtime{MaxSteps: *maxSteps, Trail: 5}
+		if *timeout > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			cfg.Context = ctx
+		}
+		return runSilent(tape, sub, cfg)
+	}
+	verdict := func(accept bool) string {
+		if accept {
+			return "accept"
+		}
+		return "reject"
+	}
+	counterexamples := func(misses []string) {
+		for k, s := range misses {
+			if k == *show {
+				fmt.Printf("  ... and %d more\n", len(misses)-k)
+				break
+			}
+			fmt.Println("  " + s)
+		}
+	}
+	failed := false
+
+	if *suitePath != "" {
+		cases, cons, err := readSuite(*suitePath)
+		if err != nil {
+			fmt.Println("suite error:", err)
+			os.Exit(2)
+		}
+		if len(cons) > 0 {
+			// the assignment's limits, checked before any tape runs
+			suite := junitSuite{Name: "constraints", Time: junitSeconds(0)}
+			broken := 0
+			for _, c := range cons {
+				violations := c.check(sub)
+				if len(violations) > 0 {
+					broken++
+					for _, v := range violations {
+						fmt.Printf("  line %d: %s: %s\n", c.line, c.text, v)
+					}
+				}
+				suite.addCheck(fmt.Sprintf("line %d: %s", c.line, c.text), violations)
+			}
+			fmt.Printf("constraints of %s: %d of %d hold\n", *suitePath, len(cons)-broken, len(cons))
+			suites = append(suites, suite)
+			failed = failed || broken > 0
+		}
+		var misses []string
+		suite := junitSuite{Name: *suitePath}
+		began := time.Now()
+		for _, c := range cases {
+			start := time.Now()
+			res := runSub(c.tape)
+			miss := ""
+			if res.Accepted != c.accept {
+				miss = fmt.Sprintf("expected %s, got %s", verdict(c.accept), res.Outcome)
+				misses = append(misses, fmt.Sprintf("line %d: %s: %s", c.line, c.tape, miss))
+			}
+			suite.add(fmt.Sprintf("line %d: %s", c.line, c.tape), time.Since(start), miss, res)
+		}
+		suite.Time = junitSeconds(time.Since(began))
+		suites = append(suites, suite)
+		passed := len(cases) - len(misses)
+		fmt.Printf("suite %s: %d of %d passed\n", *suitePath, passed, len(cases))
+		counterexamples(misses)
+		failed = failed || passed < len(cases)
+	}
+
+	if *refPath != "" {
+		ref, err := load(*refPath, *strict, os.Stderr)
+		if err != nil {
+			fmt.Printf("%s: %v\n", *refPath, err)
+			os.Exit(2)
+		}
+		alpha := *alphabet
+		if alpha == "" {
+			alpha, _ = ref.Alphabet()
+		}
+		if alpha == "" || strings.Contains(alpha, "#") {
+			fmt.Println("alphabet error: need at least one input symbol, and '#' is the endmarker")
+			os.Exit(2)
+		}
+		refCfg := machine.Runtime{MaxSteps: 1000000}
+		total := 0
+		var misses []string
+		suite := junitSuite{Name: "against " + *refPath}
+		began := time.Now()
+		machine.ForEachWord(alpha, *maxLen, func(w string) bool {
+			tape := "#" + w + "#"
+			total++
+			want := runSilent(tape, ref, refCfg).Accepted
+			start := time.Now()
+			res := runSub(tape)
+			miss := ""
+			if res.Accepted != want {
+				miss = fmt.Sprintf("expected %s, got %s", verdict(want), res.Outcome)
+				misses = append(misses, tape+": "+miss)
+			}
+			suite.add(tape, time.Since(start), miss, res)
+			return true
+		})
+		suite.Time = junitSeconds(time.Since(began))
+		suites = append(suites, suite)
+		fmt.Printf("against %s: %d of %d inputs over {%s} up to length %d agree\n",
+			*refPath, total-len(misses), total, strings.Join(strings.Split(alpha, ""), ","), *maxLen)
+		counterexamples(misses)
+		failed = failed || len(misses) > 0
+	}
+
+	writeReport()
+	if failed {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
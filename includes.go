@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// included decides whether every input the DFA a accepts, b accepts too,
+// by searching the product of the two for a state where a accepts and b
+// does not. It returns a shortest such input when there is one.
+func included(alphabet string, da [][]int, aa []bool, db [][]int, ab []bool) (counter string, ok bool) {
+	type pair struct{ p, q int }
+	type via struct {
+		prev pair
+		sym  byte
+	}
+	start := pair{0, 0}
+	seen := map[pair]via{start: {}}
+	for queue := []pair{start}; len(queue) > 0; queue = queue[1:] {
+		x := queue[0]
+		if aa[x.p] && !ab[x.q] {
+			var w []byte
+			for ; x != start; x = seen[x].prev {
+				w = append(w, seen[x].sym)
+			}
+			for i, j := 0, len(w)-1; i < j; i, j = i+1, j-1 {
+				w[i], w[j] = w[j], w[i]
+			}
+			return string(w), false
+		}
+		for j := 0; j < len(alphabet); j++ {
+			y := pair{da[x.p][j], db[x.q][j]}
+			if _, ok := seen[y]; !ok {
+				seen[y] = via{x, alphabet[j]}
+				queue = append(queue, y)
+			}
+		}
+	}
+	return "", true
+}
+
+// includesCmd decides L(a) ⊆ L(b) for two machines, exiting 1 with a
+// counterexample when a accepts an input b does not.
+func includesCmd(args []string) {
+	fs := flag.NewFlagSet("includes", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules files as errors")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: go run . includes [flags] <a.txt> <b.txt>")
+		fs.PrintDefaults()
+		return
+	}
+
+	// both machines run over the union of their alphabets; a symbol one
+	// of them does not know makes it reject
+	var ms [2]*machine.Machine
+	alphabet := ""
+	for k, path := range args {
+		m, err := loadDeterministic(path, *strict, os.Stderr)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			os.Exit(2)
+		}
+		alpha, open := m.Alphabet()
+		if open || alpha == "" {
+			fmt.Printf("%s: declare the input symbols with an alphabet: header\n", path)
+			os.Exit(2)
+		}
+		for i := 0; i < len(alpha); i++ {
+			if strings.IndexByte(alphabet, alpha[i]) < 0 {
+				alphabet += alpha[i : i+1]
+			}
+		}
+		ms[k] = m
+	}
+	var delta [2][][]int
+	var accept [2][]bool
+	for k, m := range ms {
+		if delta[k], accept[k], err = automaton(m, alphabet); err != nil {
+			fmt.Printf("%s: %v\n", args[k], err)
+			os.Exit(2)
+		}
+	}
+
+	counter, ok := included(alphabet, delta[0], accept[0], delta[1], accept[1])
+	if !ok {
+		fmt.Printf("%s accepts %q, %s does not\n", args[0], "#"+counter+"#", args[1])
+		os.Exit(1)
+	}
+	fmt.Printf("every input %s accepts, %s accepts too\n", args[0], args[1])
+}
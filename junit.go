@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"project_twa/pkg/machine"
+)
+
+// junitSuites is the root of a JUnit XML report, the format CI servers and
+// LMS plugins read test results in.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// junitSeconds is d as JUnit writes times: seconds with a fraction.
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// add records a case in the suite: a failure if msg is not "", with the
+// run's reason and last steps as its text.
+func (s *junitSuite) add(name string, d time.Duration, msg string, res machine.Result) {
+	c := junitCase{Name: name, ClassName: s.Name, Time: junitSeconds(d)}
+	s.Tests++
+	if msg != "" {
+		s.Failures++
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s after %d steps: %s\n", res.Outcome, res.Steps, res.Reason)
+		if len(res.Last) > 0 {
+			fmt.Fprintf(&b, "last %d steps:\n", len(res.Last))
+			for _, ev := range res.Last {
+				fmt.Fprintln(&b, " ", stepRow(ev), " ", machine.HighlightIndex(ev.Cells, ev.Head))
+			}
+		}
+		c.Failure = &junitFailure{Message: msg, Type: "verdict", Text: b.String()}
+	}
+	s.Cases = append(s.Cases, c)
+}
+
+// addCheck records a case that is not a run, such as a constraint: a
+// failure listing the problems, if there are any.
+func (s *junitSuite) addCheck(name string, problems []string) {
+	c := junitCase{Name: name, ClassName: s.Name, Time: junitSeconds(0)}
+	s.Tests++
+	if len(problems) > 0 {
+		s.Failures++
+		c.Failure = &junitFailure{Message: problems[0], Type: "constraint", Text: strings.Join(problems, "\n")}
+	}
+	s.Cases = append(s.Cases, c)
+}
+
+// writeJUnit writes the suites as a JUnit XML report to path.
+func writeJUnit(path string, suites []junitSuite) error {
+	root := junitSuites{Suites: suites}
+	for _, s := range suites {
+		root.Tests += s.Tests
+		root.Failures += s.Failures
+	}
+	out, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(out, '\n')...), 0o644)
+}
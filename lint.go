@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"project_twa/pkg/machine"
+)
+
+// lintFile parses and validates one rules file without running it and
+// returns everything found, each diagnostic tagged with path. Path "-"
+// reads the rules from stdin.
+func lintFile(path string, strict bool) []machine.Diagnostic {
+	var diags []machine.Diagnostic
+	var rs *machine.Rules
+	var err error
+	if path == "-" {
+		rs, err = machine.ParseRulesFrom(os.Stdin, strict)
+	} else {
+		rs, err = machine.ParseRules(path, strict)
+	}
+	var d machine.Diagnostic
+	switch {
+	case errors.As(err, &d):
+		diags = []machine.Diagnostic{d}
+	case err != nil:
+		diags = []machine.Diagnostic{{Severity: machine.SevError, Code: "read", Message: err.Error()}}
+	default:
+		diags = machine.Validate(rs, strict)
+	}
+	for i := range diags {
+		diags[i].File = path
+	}
+	return diags
+}
+
+// lintCmd checks rules files and prints what it finds, one
+// file:line:column: line per problem, or as JSON for editors and CI, or as
+// SARIF for code scanning. It exits 1 if any file has an error.
+func lintCmd(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	asJSON := fs.Bool("json", false, "print the diagnostics as JSON (see result.schema.json)")
+	asSARIF := fs.Bool("sarif", false, "print the diagnostics as SARIF 2.1.0, for code-scanning UIs")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) == 0 {
+		fmt.Println("Usage: go run . lint [flags] <rules.txt>...")
+		fs.PrintDefaults()
+		return
+	}
+	if *asJSON && *asSARIF {
+		fmt.Println("lint error: --json and --sarif each print the whole report; give one of them")
+		return
+	}
+
+	diags := []machine.Diagnostic{}
+	for _, path := range args {
+		diags = append(diags, lintFile(path, *strict)...)
+	}
+
+	failed := false
+	for _, d := range diags {
+		if d.Severity == machine.SevError {
+			failed = true
+		}
+	}
+	switch {
+	case *asSARIF:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(toSARIF(diags))
+	case *asJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(machine.LintReport{Schema: machine.SchemaVersion, Diagnostics: diags})
+	default:
+		for _, d := range diags {
+			pos := d.File
+			if d.Line > 0 {
+				pos += fmt.Sprintf(":%d", d.Line)
+				if d.Column > 0 {
+					pos += fmt.Sprintf(":%d", d.Column)
+				}
+			}
+			fmt.Printf("%s: %s: %s [%s]\n", pos, d.Severity, d.Message, d.Code)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
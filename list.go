@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// MachineInfo is one row of "list": a rules file and what it holds.
+type MachineInfo struct {
+	File        string `json:"file"`
+	Kind        string `json:"kind"`
+	States      int    `json:"states"`
+	Alphabet    string `json:"alphabet"`
+	Description string `json:"description,omitempty"`
+}
+
+// description is the // comment block at the top of a rules file, before
+// any directive or state, joined into one line.
+func description(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var parts []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" && len(parts) == 0 {
+			continue
+		}
+		text, ok := strings.CutPrefix(line, "//")
+		if !ok {
+			break
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// listCmd prints the machines under a directory: every .txt file that
+// loads as rules, with its kind, state count, alphabet and description.
+func listCmd(args []string) {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	dir := flags.String("machines-dir", ".", "directory to search for rules files, with its subdirectories")
+	asJSON := flags.Bool("json", false, "print the list as JSON")
+	args, err := parseArgs(flags, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 0 {
+		fmt.Println("Usage: go run . list [--machines-dir dir] [--json]")
+		flags.PrintDefaults()
+		return
+	}
+
+	infos := []MachineInfo{}
+	skipped := 0
+	err = filepath.WalkDir(*dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".txt" {
+			return err
+		}
+		m, err := load(path, false, io.Discard)
+		if err != nil {
+			skipped++ // not rules, or broken ones: lint tells which
+			return nil
+		}
+		n := 0
+		for _, s := range m.States {
+			if s.Defined && !s.Implicit {
+				n++
+			}
+		}
+		alphabet, open := m.Alphabet()
+		alphabet = "{" + strings.Join(strings.Split(alphabet, ""), ",") + "}"
+		if open {
+			alphabet += " *"
+		}
+		rel, _ := filepath.Rel(*dir, path)
+		infos = append(infos, MachineInfo{File: rel, Kind: m.KindName(), States: n, Alphabet: alphabet, Description: description(path)})
+		return nil
+	})
+	if err != nil {
+		fmt.Println("list error:", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(infos)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tKIND\tSTATES\tALPHABET\tDESCRIPTION")
+	for _, in := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", in.File, in.Kind, in.States, in.Alphabet, in.Description)
+	}
+	tw.Flush()
+	if skipped > 0 {
+		fmt.Printf("(%d other .txt files are not loadable rules; run lint on them to see why)\n", skipped)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// lspCmd serves the Language Server Protocol on stdin/stdout for rules
+// files: diagnostics as the text changes, go-to-definition and hover on
+// state ids, and renaming a state. Documents are synced in full; columns
+// are byte offsets, which is what LSP's UTF-16 columns are for the ASCII
+// that rules files are written in.
+func lspCmd(args []string) {
+	fs := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	if _, err := parseArgs(fs, args); err != nil {
+		return
+	}
+	s := &lspServer{out: os.Stdout, docs: map[string]string{}, strict: *strict}
+	if err := s.serve(os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, "lsp:", err)
+		os.Exit(1)
+	}
+	if !s.shutdown {
+		os.Exit(1)
+	}
+}
+
+type lspServer struct {
+	out      io.Writer
+	docs     map[string]string // uri -> text
+	strict   bool
+	shutdown bool
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"` // 1 error, 2 warning
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// positionParams covers the requests that point at a place in a document.
+type positionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+	NewName  string      `json:"newName"` // rename only
+}
+
+// serve reads messages until exit or end of input.
+func (s *lspServer) serve(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		body, err := readFrame(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return fmt.Errorf("bad message: %w", err)
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue // notification: nothing to answer
+		}
+		reply := rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: rerr}
+		if rerr == nil {
+			b, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			reply.Result = b
+		}
+		if err := s.send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// readFrame reads one Content-Length framed message body.
+func readFrame(br *bufio.Reader) ([]byte, error) {
+	n := -1
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && n < 0 {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if key, val, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, "Content-Length") {
+			if n, err = strconv.Atoi(strings.TrimSpace(val)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", val)
+			}
+		}
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, n)
+	_, err := io.ReadFull(br, body)
+	return body, err
+}
+
+func (s *lspServer) send(msg rpcMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+func (s *lspServer) notify(method string, params any) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.send(rpcMessage{JSONRPC: "2.0", Method: method, Params: b})
+}
+
+func (s *lspServer) handle(msg rpcMessage) (any, *rpcError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"renameProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "twa"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil {
+			s.docs[p.TextDocument.URI] = p.TextDocument.Text
+			s.publish(p.TextDocument.URI)
+		}
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil && len(p.ContentChanges) > 0 {
+			s.docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+			s.publish(p.TextDocument.URI)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p positionParams
+		if json.Unmarshal(msg.Params, &p) == nil {
+			delete(s.docs, p.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", map[string]any{
+				"uri": p.TextDocument.URI, "diagnostics": []lspDiagnostic{},
+			})
+		}
+		return nil, nil
+	case "textDocument/definition", "textDocument/hover", "textDocument/rename":
+		var p positionParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, &rpcError{Code: -32602, Message: err.Error()}
+		}
+		text, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, &rpcError{Code: -32602, Message: "document is not open"}
+		}
+		toks := stateTokens(text)
+		at := tokenAt(toks, p.Position)
+		if at == nil {
+			return nil, nil
+		}
+		switch msg.Method {
+		case "textDocument/definition":
+			for _, t := range toks {
+				if t.def && t.id == at.id {
+					return lspLocation{URI: p.TextDocument.URI, Range: t.rng()}, nil
+				}
+			}
+			return nil, nil
+		case "textDocument/hover":
+			return map[string]any{
+				"contents": map[string]string{"kind": "markdown", "value": stateHover(text, toks, at.id)},
+				"range":    at.rng(),
+			}, nil
+		}
+		id, err := machine.ParseStateID(p.NewName)
+		if err != nil {
+			return nil, &rpcError{Code: -32602, Message: "a state is renamed to a positive number"}
+		}
+		var edits []lspTextEdit
+		for _, t := range toks {
+			if t.id == at.id {
+				edits = append(edits, lspTextEdit{Range: t.rng(), NewText: strconv.Itoa(id)})
+			}
+		}
+		return map[string]any{"changes": map[string][]lspTextEdit{p.TextDocument.URI: edits}}, nil
+	}
+	if msg.ID != nil {
+		return nil, &rpcError{Code: -32601, Message: "method not found: " + msg.Method}
+	}
+	return nil, nil
+}
+
+// publish sends the parser's and validator's findings for a document.
+func (s *lspServer) publish(uri string) {
+	text := s.docs[uri]
+	lines := strings.Split(text, "\n")
+	var diags []machine.Diagnostic
+	rs, err := machine.ParseRulesFrom(strings.NewReader(text), s.strict)
+	var d machine.Diagnostic
+	switch {
+	case errors.As(err, &d):
+		diags = []machine.Diagnostic{d}
+	case err == nil:
+		diags = machine.Validate(rs, s.strict)
+	}
+
+	out := []lspDiagnostic{}
+	for _, d := range diags {
+		var r lspRange
+		if d.Line > 0 && d.Line <= len(lines) {
+			end := len(strings.TrimRight(lines[d.Line-1], "\r"))
+			r.Start = lspPosition{Line: d.Line - 1, Character: max(d.Column-1, 0)}
+			r.End = lspPosition{Line: d.Line - 1, Character: max(end, r.Start.Character)}
+		}
+		sev := 1
+		if d.Severity == machine.SevWarning {
+			sev = 2
+		}
+		out = append(out, lspDiagnostic{Range: r, Severity: sev, Code: d.Code, Source: "twa", Message: d.Message})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": out})
+}
+
+// stateToken is a state id in the text: the one before "]" that defines
+// the state, or the target of a (sym,to) pair. Positions are 0-based.
+type stateToken struct {
+	line, col, end int
+	id             int
+	def            bool
+}
+
+func (t stateToken) rng() lspRange {
+	return lspRange{Start: lspPosition{t.line, t.col}, End: lspPosition{t.line, t.end}}
+}
+
+// stateTokens finds the state ids in rules text. It is lenient where the
+// parser is not, so that navigation keeps working while a line is being
+// typed.
+func stateTokens(text string) []stateToken {
+	var toks []stateToken
+	// add records s[i:j] of line ln if it is a state id.
+	add := func(ln int, s string, i, j int, def bool) {
+		for i < j && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		for j > i && (s[j-1] == ' ' || s[j-1] == '\t') {
+			j--
+		}
+		if id, err := machine.ParseStateID(s[i:j]); err == nil {
+			toks = append(toks, stateToken{line: ln, col: i, end: j, id: id, def: def})
+		}
+	}
+	for ln, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "# ") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "@group") {
+			// @group name id id ...: every word after the name
+			fields := strings.Fields(trimmed)
+			at := strings.Index(line, "@group") + len("@group")
+			for k, f := range fields[1:] {
+				at += strings.Index(line[at:], f)
+				if k > 0 {
+					add(ln, line, at, at+len(f), false)
+				}
+				at += len(f)
+			}
+			continue
+		}
+		rb := strings.IndexByte(line, ']')
+		if rb < 0 {
+			continue
+		}
+		add(ln, line, 0, rb, true)
+		for i := rb + 1; i < len(line); i++ {
+			if line[i] != '(' {
+				continue
+			}
+			end := strings.IndexByte(line[i:], ')')
+			if end < 0 {
+				end = len(line) - i
+			}
+			inside := line[i+1 : i+end]
+			if c1 := strings.IndexByte(inside, ','); c1 >= 0 {
+				from := i + 1 + c1 + 1
+				to := i + end
+				if c2 := strings.IndexByte(inside[c1+1:], ','); c2 >= 0 {
+					to = from + c2
+				}
+				add(ln, line, from, to, false)
+			}
+			i += end
+		}
+	}
+	return toks
+}
+
+func tokenAt(toks []stateToken, pos lspPosition) *stateToken {
+	for i, t := range toks {
+		if t.line == pos.Line && pos.Character >= t.col && pos.Character <= t.end {
+			return &toks[i]
+		}
+	}
+	return nil
+}
+
+// stateHover shows the lines defining state id and how often it is a
+// target.
+func stateHover(text string, toks []stateToken, id int) string {
+	lines := strings.Split(text, "\n")
+	var b strings.Builder
+	refs := 0
+	b.WriteString("```\n")
+	for _, t := range toks {
+		if t.id != id {
+			continue
+		}
+		if t.def {
+			b.WriteString(strings.TrimSpace(lines[t.line]) + "\n")
+		} else {
+			refs++
+		}
+	}
+	b.WriteString("```\n")
+	if id == 1 {
+		b.WriteString("start state; ")
+	}
+	fmt.Fprintf(&b, "target of %d transition", refs)
+	if refs != 1 {
+		b.WriteString("s")
+	}
+	return b.String()
+}
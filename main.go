@@ -2,257 +2,86 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
-	"time"
-)
-
-type Move int8
-
-const (
-	L Move = -1
-	R Move = +1
-)
+	"unicode"
+	"unicode/utf8"
 
-type StepStatus int
-
-const (
-	Continue StepStatus = iota
-	Accept
-	Reject
+	"project_twa/pkg/machine"
 )
 
-type State struct {
-	id     int
-	dir    Move
-	next   map[uint8]*State
-	accept bool
-	reject bool
-}
-
-func (s *State) nextOn(sym byte) (*State, error) {
-
-	if state, ok := s.next[sym]; ok {
-
-		return state, nil
-	} else {
-		return nil, fmt.Errorf("invalid symbol %q", sym)
-	}
-
-}
-
-func (s *State) Step(tape string, i int) (*State, int, StepStatus, error) {
-
-	displayTapeWithHead(tape, i)
-
-	nxt, err := s.nextOn(tape[i])
-	if err != nil {
-		return nil, i, Continue, err
-	}
-	if nxt == nil {
-		return nil, i, Continue, fmt.Errorf("missing transition: state %d on %q", s.id, tape[i])
-	}
-	if nxt.accept {
-		return nxt, i, Accept, nil
-	}
-	if nxt.reject {
-		return nxt, i, Reject, nil
-	}
-	if nxt.dir == L {
-		i--
+// dump prints the states and their transitions; with visits, each state
+// also shows how often the last run entered it.
+func dump(w io.Writer, states []*machine.State, visits map[int]int) {
+	if visits != nil {
+		fmt.Fprintln(w, "=== FSM (node graph, visits in the last run) ===")
 	} else {
-		i++
-	}
-	return nxt, i, Continue, nil
-}
-
-type rawLine struct {
-	id    int
-	dir   Move
-	pairs [][2]string
-	acc   bool
-	rej   bool
-}
-
-func (m Move) String() string {
-	if m == L {
-		return "L"
-	}
-	return "R"
-}
-
-func parseMoveLR(s string) (Move, bool) {
-	switch strings.ToLower(strings.TrimSpace(s)) {
-	case "left", "l":
-		return L, true
-	case "right", "r":
-		return R, true
-	default:
-		return 0, false
+		fmt.Fprintln(w, "=== FSM (node graph) ===")
 	}
-}
-
-func parseRules(path string) ([]rawLine, int, error) {
-
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	defer f.Close()
-
-	var lines []rawLine
-	maxID := 0
-	sc := bufio.NewScanner(f)
-	ln := 0
-
-	for sc.Scan() {
-		ln++
-		line := strings.TrimSpace(sc.Text())
-		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "# ") {
-			continue
-		}
-		// q] accept / reject
-		if i := strings.Index(line, "]"); i > 0 && strings.Contains(line, "accept") {
-			id, e := strconv.Atoi(strings.TrimSpace(line[:i]))
-			if e != nil {
-				return nil, 0, fmt.Errorf("line %d: %v", ln, e)
-			}
-			lines = append(lines, rawLine{id: id, acc: true})
-			if id > maxID {
-				maxID = id
-			}
-			continue
-		}
-		if i := strings.Index(line, "]"); i > 0 && strings.Contains(line, "reject") {
-			id, e := strconv.Atoi(strings.TrimSpace(line[:i]))
-			if e != nil {
-				return nil, 0, fmt.Errorf("line %d: %v", ln, e)
-			}
-			lines = append(lines, rawLine{id: id, rej: true})
-			if id > maxID {
-				maxID = id
-			}
+	for id := 1; id < len(states); id++ {
+		s := states[id]
+		if s == nil {
 			continue
 		}
-
-		// q] left|right (x,y) (x,y) ...
-		parts := strings.SplitN(line, "]", 2)
-		if len(parts) != 2 {
-			return nil, 0, fmt.Errorf("line %d: bad syntax", ln)
+		tag := ""
+		if s.Accept {
+			tag += " [ACCEPT]"
 		}
-		id, e := strconv.Atoi(strings.TrimSpace(parts[0]))
-		if e != nil {
-			return nil, 0, fmt.Errorf("line %d: %v", ln, e)
+		if s.Final {
+			tag += " [FINAL]"
 		}
-		rest := strings.TrimSpace(parts[1])
-
-		lp := strings.IndexByte(rest, '(')
-		if lp < 0 {
-			return nil, 0, fmt.Errorf("line %d: missing '('", ln)
+		if s.Reject {
+			tag += " [REJECT]"
 		}
-		dirStr := strings.TrimSpace(rest[:lp])
-		dir, ok := parseMoveLR(dirStr)
-		if !ok {
-			return nil, 0, fmt.Errorf("line %d: move must be left/right, got %q", ln, dirStr)
+		if s.Implicit {
+			tag += " (implicit)"
 		}
-
-		var pairs [][2]string
-		right := rest[lp:]
-		for {
-			l := strings.IndexByte(right, '(')
-			r := strings.IndexByte(right, ')')
-			if l < 0 || r < 0 || r < l {
-				break
-			}
-			inside := strings.TrimSpace(right[l+1 : r]) // "a,2"
-			right = right[r+1:]
-			xy := strings.Split(inside, ",")
-			if len(xy) != 2 {
-				return nil, 0, fmt.Errorf("line %d: expect (sym,to)", ln)
-			}
-			sym := strings.TrimSpace(xy[0])
-			to := strings.TrimSpace(xy[1])
-			if len(sym) != 1 {
-				return nil, 0, fmt.Errorf("line %d: bad symbol %q", ln, sym)
-			}
-			if _, e := strconv.Atoi(to); e != nil {
-				return nil, 0, fmt.Errorf("line %d: bad to-state %q", ln, to)
-			}
-			pairs = append(pairs, [2]string{sym, to})
-			if v, _ := strconv.Atoi(to); v > maxID {
-				maxID = v
+		for k, ln := range s.Lines {
+			if k == 0 {
+				tag += fmt.Sprintf(" line=%d", ln)
+			} else {
+				tag += fmt.Sprintf(",%d", ln)
 			}
 		}
-		lines = append(lines, rawLine{id: id, dir: dir, pairs: pairs})
-		if id > maxID {
-			maxID = id
+		if visits != nil {
+			tag += fmt.Sprintf(" visits=%d", visits[s.ID])
 		}
-	}
-	if e := sc.Err(); e != nil {
-		return nil, 0, e
-	}
-	if maxID == 0 {
-		return nil, 0, fmt.Errorf("no states parsed")
-	}
-	return lines, maxID, nil
-}
-
-func buildGraph(lines []rawLine, maxID int) ([]*State, *State, error) {
-
-	st := make([]*State, maxID+1)
-	for i := 0; i <= maxID; i++ {
-		st[i] = &State{id: i, dir: R}
-	}
-
-	for _, ln := range lines {
-		s := st[ln.id]
-		if ln.acc {
-			s.accept = true
+		fmt.Fprintf(w, "%d] dir=%s%s  ", s.ID, s.Dir, tag)
+		for _, e := range s.Eps {
+			fmt.Fprintf(w, "(%s) ", edgeLabel("ε", e))
 		}
-		if ln.rej {
-			s.reject = true
-		}
-		if len(ln.pairs) > 0 {
-			s.dir = ln.dir
+		for _, key := range s.Order {
+			for _, e := range s.EdgesOn(key) {
+				fmt.Fprintf(w, "(%s) ", edgeLabel(string(key), e))
+			}
 		}
-		for _, p := range ln.pairs {
-			toID, _ := strconv.Atoi(p[1])
-			if s.next == nil {
-				s.next = make(map[uint8]*State)
+		if s.Other != nil {
+			for _, e := range append([]machine.Edge{*s.Other}, s.OtherAlts...) {
+				fmt.Fprintf(w, "(%s) ", edgeLabel("*", e))
 			}
-			s.next[p[0][0]] = st[toID]
 		}
-
+		fmt.Fprintln(w)
 	}
-	return st, st[1], nil
 }
 
-func dump(states []*State) {
-	fmt.Println("=== FSM (node graph) ===")
-	for id := 1; id < len(states); id++ {
-		s := states[id]
-		if s == nil {
-			continue
-		}
-		tag := ""
-		if s.accept {
-			tag += " [ACCEPT]"
-		}
-		if s.reject {
-			tag += " [REJECT]"
-		}
-		fmt.Printf("%d] dir=%s%s  ", s.id, s.dir, tag)
-		for key, _ := range s.next {
-			fmt.Printf("(%d->%c) ", s.id, key)
-		}
-		fmt.Println()
+func edgeLabel(sym string, e machine.Edge) string {
+	if e.Implicit {
+		return fmt.Sprintf("%s->%d implicit", sym, e.To.ID)
+	}
+	if e.Dir != 0 {
+		return fmt.Sprintf("%s->%d,%s", sym, e.To.ID, e.Dir)
 	}
+	return fmt.Sprintf("%s->%d", sym, e.To.ID)
 }
 
-func writeDOT(states []*State, path string) error {
+func writeDOT(m *machine.Machine, path string) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return err
@@ -261,6 +90,7 @@ func writeDOT(states []*State, path string) error {
 
 	fmt.Fprintln(f, "digraph FSM {")
 	fmt.Fprintln(f, `  rankdir=LR; node [shape=circle, fontname="Arial"];`)
+	states := m.States
 	for id := 1; id < len(states); id++ {
 		s := states[id]
 		if s == nil {
@@ -268,144 +98,689 @@ func writeDOT(states []*State, path string) error {
 		}
 		shape := "circle"
 		color := ""
-		if s.accept {
+		if s.Accept || s.Final {
 			shape = "doublecircle"
 			color = `, color="green"`
 		}
-		if s.reject {
+		if s.Reject {
 			shape = "octagon"
 			color = `, color="red"`
 		}
-		lbl := fmt.Sprintf("%d\\n[%s]", s.id, s.dir)
-		fmt.Fprintf(f, "  %d [label=\"%s\", shape=%s%s];\n", s.id, lbl, shape, color)
+		if s.Implicit {
+			color += ", style=dashed"
+		}
+		if w := s.Where(); w != "" {
+			color += ", tooltip=" + dotQuote(w)
+		}
+		lbl := fmt.Sprintf("%d\n[%s]", s.ID, s.Dir)
+		fmt.Fprintf(f, "  %d [label=%s, shape=%s%s];\n", s.ID, dotQuote(lbl), shape, color)
 
-		for key, value := range s.next {
-			fmt.Fprintf(f, "  %d -> %d [label=\"%c\"];\n", s.id, value.id, key)
+		for _, e := range s.Eps {
+			fmt.Fprintf(f, "  %d -> %d [label=%s%s];\n", s.ID, e.To.ID, dotQuote("ε"), dotTooltip(e))
+		}
+		for _, key := range s.Order {
+			for _, e := range s.EdgesOn(key) {
+				fmt.Fprintf(f, "  %d -> %d [label=%s%s];\n", s.ID, e.To.ID, dotQuote(dotEdgeLabel(string([]byte{key}), e)), dotTooltip(e))
+			}
+		}
+		if s.Other != nil {
+			for _, e := range append([]machine.Edge{*s.Other}, s.OtherAlts...) {
+				style := ""
+				if e.Implicit {
+					style = ", style=dashed"
+				}
+				fmt.Fprintf(f, "  %d -> %d [label=%s%s%s];\n", s.ID, e.To.ID, dotQuote(dotEdgeLabel("*", e)), style, dotTooltip(e))
+			}
+		}
+	}
+	fmt.Fprintf(f, "  legend [shape=note, fontsize=10, label=%s];\n", dotQuote(dotLegend(m)))
+	for i, g := range m.Groups {
+		fmt.Fprintf(f, "  subgraph cluster_%d {\n    label=%s; style=rounded; color=gray;\n   ", i, dotQuote(g.Name))
+		for _, id := range g.IDs {
+			if id < len(states) && states[id] != nil {
+				fmt.Fprintf(f, " %d;", id)
+			}
 		}
+		fmt.Fprintln(f, "\n  }")
 	}
 	fmt.Fprintln(f, "}")
 	return nil
 }
 
-func highlightIndex(tape string, head int) string {
-	if head < 0 || head >= len(tape) {
-		// 越界时就原样返回；按需你也可以在这里加提示
-		return tape
+// dotLegend is the text of the DOT legend note: what the machine is, how
+// it accepts, and what the shapes mean, so a shared diagram explains itself.
+func dotLegend(m *machine.Machine) string {
+	var lines []string
+	if m.Name != "" {
+		lines = append(lines, m.Name)
 	}
+	alphabet, open := m.Alphabet()
+	switch {
+	case open && alphabet == "":
+		alphabet = "any symbol"
+	case open:
+		alphabet = "{" + strings.Join(strings.Split(alphabet, ""), ",") + "} and any other symbol"
+	default:
+		alphabet = "{" + strings.Join(strings.Split(alphabet, ""), ",") + "}"
+	}
+	if m.Kind == machine.OneWay {
+		lines = append(lines,
+			"kind: "+m.KindName()+" (one-way)",
+			"alphabet: "+alphabet,
+			"accepts if the input ends in an accepting state",
+			"double circle: accepting state",
+			"octagon: reject state, halts at once")
+	} else {
+		bounds := "leaving the tape ends the run out of bounds"
+		switch {
+		case m.Bounce:
+			bounds = "# only marks the ends; moving past one rejects"
+		case m.OnBounds == machine.BoundsReject:
+			bounds = "leaving the tape rejects"
+		case m.OnBounds == machine.BoundsClamp:
+			bounds = "the head stays on an endmarker it would leave"
+		}
+		lines = append(lines,
+			"kind: "+m.KindName()+" (two-way)",
+			"alphabet: "+alphabet,
+			"accepts on entering an accept state",
+			bounds,
+			"double circle: accept state, halts",
+			"octagon: reject state, halts",
+			"[L]/[R]: the state's direction; x/L on an edge overrides it")
+	}
+	if m.Nondet {
+		lines = append(lines, "edges on the same symbol are choices; a run accepts if any branch does")
+	}
+	if m.Implicit() {
+		lines = append(lines, "dashed: added by on-missing")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dotQuote makes s a quoted DOT string that shows s as it is. Quotes and
+// backslashes are escaped, a newline becomes a line break, and bytes that
+// are not printable text (control bytes, invalid UTF-8) show as \xNN, so no
+// symbol can end the string early or turn into an escape of its own.
+// Quoted strings are never HTML-like labels, so <, > and & need nothing.
+func dotQuote(s string) string {
 	var b strings.Builder
-	b.Grow(len(tape) + 2)
-	b.WriteString(tape[:head])
-	b.WriteByte('[')
-	b.WriteByte(tape[head])
-	b.WriteByte(']')
-	if head+1 < len(tape) {
-		b.WriteString(tape[head+1:])
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == utf8.RuneError && n == 1 || !unicode.IsPrint(r):
+			for k := 0; k < n; k++ {
+				fmt.Fprintf(&b, `\\x%02X`, s[i+k])
+			}
+		default:
+			b.WriteString(s[i : i+n])
+		}
+		i += n
 	}
+	b.WriteByte('"')
 	return b.String()
 }
 
-func displayTapeWithHead(tape string, head int) {
-	fmt.Println("Tape :", highlightIndex(tape, head))
+// dotTooltip is the attribute showing, on hover, the rules-file line an
+// edge was written on: ", tooltip=\"line 3\"", or "" for edges no line
+// wrote.
+func dotTooltip(e machine.Edge) string {
+	if e.Line == 0 {
+		return ""
+	}
+	return ", tooltip=" + dotQuote(fmt.Sprintf("line %d", e.Line))
 }
 
-func dirStr(m Move) string {
-	if m == L {
-		return "L"
+func dotEdgeLabel(sym string, e machine.Edge) string {
+	if e.Dir != 0 {
+		return sym + "/" + e.Dir.String()
 	}
-	return "R"
+	return sym
 }
 
-func run(tape string, start *State) (bool, error) {
+// run runs with the trace and pacing of the command line.
+func run(tape string, m *machine.Machine, tr *tracer, pc *pacer, cfg machine.Runtime) machine.Result {
+	if cfg.Context != nil {
+		pc.Done = cfg.Context.Done() // so waits between steps end with it
+	}
+	if onStep := cfg.OnStep; onStep != nil {
+		cfg.OnStep = func(ev machine.StepEvent) {
+			tr.step(ev)
+			onStep(ev)
+		}
+	} else {
+		cfg.OnStep = tr.step
+	}
+	cfg.Wait = pc.wait
+	tr.begin()
+	defer tr.end()
+	return cfg.Run(m, tape)
+}
 
-	var (
-		q, i, step = start, 1, 1
-	)
+// runSilent runs without a trace or delay.
+func runSilent(tape string, m *machine.Machine, cfg machine.Runtime) machine.Result {
+	return cfg.Run(m, tape)
+}
 
-	fmt.Println("== TRACE START ==")
+// filterLines prints the lines of r the machine accepts, like grep: each
+// line is run as the tape #line#, on the compiled table unless a monitor
+// or an nfa needs the interpreter. Lines with symbols outside the
+// alphabet are not accepted. It reports whether any line was.
+func filterLines(r io.Reader, w io.Writer, m *machine.Machine, cfg machine.Runtime) (bool, error) {
+	var t *table
+	if !m.Nondet {
+		t = compile(m)
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	matched := false
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		tape := "#" + line + "#"
+		if m.CheckTape(tape) != nil {
+			continue
+		}
+		var ok bool
+		if t == nil || cfg.Monitor != nil {
+			ok = runSilent(tape, m, cfg).Accepted
+		} else {
+			o, _ := t.run(machine.NewByteTape(tape), cfg.MaxSteps)
+			ok = o == machine.Accepted
+		}
+		if ok {
+			matched = true
+			fmt.Fprintln(bw, line)
+		}
+	}
+	return matched, sc.Err()
+}
 
+// parseArgs parses fs over args, letting flags appear before, between or
+// after the positional arguments, and returns the positionals in order.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
 	for {
-		fmt.Printf("=============================================\n")
-		nxt, j, st, err := q.Step(tape, i)
-		if err != nil {
-			return false, err
-		}
-
-		read := tape[i]
-
-		fmt.Printf("step  state       read  next  move  head\n")
-		fmt.Printf("%-5d %-10s  %-4s  %-4d  %-4s  %d->%d\n",
-			step,
-			fmt.Sprintf("%d(%s)", q.id, dirStr(q.dir)),
-			string(read),
-			nxt.id,
-			dirStr(nxt.dir),
-			i, j,
-		)
-
-		switch st {
-		case Accept:
-			return true, nil
-		case Reject:
-			return false, nil
-		default:
-			q, i = nxt, j
-			step++
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
 		}
-		time.Sleep(1000 * time.Millisecond)
+		pos = append(pos, args[0])
+		args = args[1:]
 	}
 }
 
-func parseTapeArg(arg string) (string, error) {
-	s := strings.TrimSpace(arg)
+// commands are the subcommands; anything else on the command line is a
+// rules file and tape to run.
+var commands = map[string]func(args []string){
+	"verify-halts": verifyHaltsCmd,
+	"analyze":      analyzeCmd,
+	"lint":         lintCmd,
+	"lsp":          lspCmd,
+	"scaffold":     scaffoldCmd,
+	"convert":      convertCmd,
+	"random":       randomCmd,
+	"difftest":     difftestCmd,
+	"includes":     includesCmd,
+	"pump":         pumpCmd,
+	"grade":        gradeCmd,
+	"compare":      compareCmd,
+	"query":        queryCmd,
+	"tracediff":    tracediffCmd,
+	"regex2nfa":    regex2nfaCmd,
+	"determinize":  determinizeCmd,
+	"mutate":       mutateCmd,
+	"list":         listCmd,
+	"codegen":      codegenCmd,
+	"tables":       tablesCmd,
+	"repl":         replCmd,
+}
 
-	if len(s) < 2 || s[0] != '#' || s[len(s)-1] != '#' {
-		return "", fmt.Errorf("tape must be wrapped with #...#")
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
 	}
+	runCmd(os.Args[1:])
+}
 
-	return s, nil
+// report prints diagnostics and tells whether the rules are usable.
+func report(w io.Writer, diags []machine.Diagnostic) bool {
+	ok := true
+	for _, d := range diags {
+		fmt.Fprintln(w, d.String())
+		if d.Severity == machine.SevError {
+			ok = false
+		}
+	}
+	return ok
 }
 
-func main() {
+// load parses, validates and builds the machine in path, printing any
+// diagnostics on the way. Path "-" reads the rules from stdin.
+func load(path string, strict bool, diags io.Writer) (*machine.Machine, error) {
+	if path == "-" {
+		m, err := loadFrom(os.Stdin, strict, diags)
+		if m != nil {
+			m.Name = "stdin"
+		}
+		return m, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	defer f.Close()
+	m, err := loadFrom(f, strict, diags)
+	if m != nil {
+		m.Name = filepath.Base(path)
+	}
+	return m, err
+}
+
+// loadDeterministic is load for the commands that need one transition per
+// symbol, such as the ones that compile or rewrite the machine: it refuses
+// an nfa.
+func loadDeterministic(path string, strict bool, diags io.Writer) (*machine.Machine, error) {
+	m, err := load(path, strict, diags)
+	if err == nil && m.Nondet {
+		return nil, fmt.Errorf("kind %s: this needs a deterministic machine (kind: %s)", m.KindName(), m.Kind)
+	}
+	return m, err
+}
+
+// loadFrom is load on rules text from r.
+func loadFrom(r io.Reader, strict bool, diags io.Writer) (*machine.Machine, error) {
+	rs, err := machine.ParseRulesFrom(r, strict)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	if !report(diags, machine.Validate(rs, strict)) {
+		return nil, errors.New("validation failed")
+	}
+	m, err := machine.BuildGraph(rs)
+	if err != nil {
+		return nil, fmt.Errorf("build error: %w", err)
+	}
+	return m, nil
+}
+
+func runCmd(args []string) {
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	noColor := fs.Bool("no-color", false, "disable ANSI colors (also off when stdout is not a terminal or NO_COLOR is set)")
+	quiet := fs.Bool("quiet", false, "print only the verdict: no dump and no trace\n(a status line on a terminal stderr shows progress)")
+	debugPath := fs.String("debug", "", "`file` of break: <condition> and watch: <field> lines, checked after every step")
+	stepMode := fs.Bool("step", false, "pause after each step: Enter steps, c continues, q quits")
+	delayFlag := fs.Duration("delay", 0, "pause this long between steps of the trace to watch the run animate, e.g. 300ms (0: none)")
+	view := fs.String("view", "line", "tape view in the trace: line or box (box redraws in place on a terminal)")
+	traceTmpl := fs.String("trace-template", "", "Go text/template for each trace line, over the step event\n(fields: Step State Dir Read Next Move Head NewHead Line, method: Tape)")
+	maxSteps := fs.Int("max-steps", 1000000, "give up after this many steps")
+	traceTail := fs.Int("trace-tail", 0, "hide the trace; if the input is not accepted, print its last `N` steps")
+	firedLines := fs.Bool("fired-lines", false, "if the input is not accepted, print the rules-file lines of the transitions the run took, in order")
+	asJSON := fs.Bool("json", false, "print the result as JSON (see result.schema.json) instead of the trace")
+	logPath := fs.String("log", "", "write the dump and full trace to this file, keeping stdout to the verdict")
+	monitorPath := fs.String("monitor", "", "dfa rules `file` watching the symbols read; the run fails when it enters a reject state")
+	timeout := fs.Duration("timeout", 0, "give up on the run after this much wall-clock time, e.g. 5s (0: no limit)")
+	inputs := fs.String("inputs", "", "run every tape in this `file` (\"#tape#\" lines, optionally with the expected accept|reject) and print a table")
+	filter := fs.Bool("filter", false, "read lines from stdin and print those the machine accepts, each run as #line#")
+	outDir := fs.String("out-dir", ".", "directory for generated files: the DOT file, and a relative --log file")
+	outName := fs.String("out-name", "fsm", "base name of the DOT file; {rules} stands for the rules file's name without extension")
+	prune := fs.Bool("prune", false, "drop unreachable states and transitions that never fire before dumping, drawing and running")
+	visits := fs.Bool("visits", false, "after the run, dump the graph again with how many times each state was entered")
+	configDot := fs.String("config-dot", "", "write the configurations the run explores, and the steps between them, as DOT to this `file`")
+	configBudget := fs.Int("config-budget", 500, "most configurations --config-dot draws")
+	traceFormat := fs.String("trace-format", "text", "trace format: text, or json for a JSON line per step and the result as the last line\n(on stdout, or in the --log file)")
+	record := fs.String("record", "", "write every step as a JSON line to this `file`, for query --trace")
+	search := fs.String("search", "bfs", "how a run of an nfa explores its choices: bfs or dfs")
+	maxDepth := fs.Int("max-depth", 0, "cut off branches of an nfa longer than this many steps (0: no limit)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 2 && !((*filter || *inputs != "") && len(args) == 1) {
+		fmt.Println("Usage: go run . [flags] <rules.txt> <#tape#>")
+		fmt.Println("       go run . --filter [flags] <rules.txt> < lines")
+		fmt.Println("       go run . --inputs <tapes.txt> [flags] <rules.txt>")
+		fmt.Println("       go run . verify-halts [flags] <rules.txt>")
+		fmt.Println("       go run . analyze <analysis> [flags] <rules.txt>")
+		fmt.Println("       go run . lint [flags] <rules.txt>...")
+		fmt.Println("       go run . convert [flags] <rules.txt>")
+		fmt.Println("       go run . includes [flags] <a.txt> <b.txt>")
+		fmt.Println("       go run . pump [flags] <rules.txt> <#tape#>")
+		fmt.Println("       go run . grade [flags] --submission <sub.txt> [--reference <ref.txt>] [--suite <tests.txt>]")
+		fmt.Println("       go run . compare --tapes <tests.txt> [flags] <rules.txt>...")
+		fmt.Println("       go run . query [flags] <rules.txt> <#tape#> <query>")
+		fmt.Println("       go run . tracediff [flags] <run1.jsonl> <run2.jsonl>")
+		fmt.Println("       go run . mutate --suite <tests.txt> [flags] <rules.txt>")
+		fmt.Println("       go run . list [--machines-dir dir] [--json]")
+		fmt.Println("       go run . codegen [flags] <rules.txt>")
+		fmt.Println("       go run . tables [flags] <rules.txt>...")
+		fmt.Println("       go run . repl [flags] <rules.txt> [\"#tape#\"]")
+		fmt.Println("       go run . scaffold [flags] <language> [args]")
+		fmt.Println("       go run . regex2nfa [flags] <regex>")
+		fmt.Println("       go run . determinize [flags] <rules.txt>")
+		fmt.Println("       go run . random [flags]")
+		fmt.Println("       go run . difftest [flags] [rules.txt...]")
+		fmt.Println("       go run . lsp [flags]")
+		fs.PrintDefaults()
+		return
+	}
+	rulesPath := args[0]
+	if *delayFlag < 0 {
+		fmt.Println("delay error: --delay cannot be negative")
+		return
+	}
+	order, err := machine.ParseSearchOrder(*search)
+	if err != nil {
+		fmt.Println("search error:", err)
+		return
+	}
 
-	if len(os.Args) != 3 {
-		fmt.Println("Usage: go run main.go <rules.txt> <tape or #tape#>")
+	// generated files go to --out-dir, named after --out-name
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fmt.Println("output error:", err)
 		return
 	}
-	rulesPath := os.Args[1]
-	tapeArg := os.Args[2]
+	rulesName := strings.TrimSuffix(filepath.Base(rulesPath), filepath.Ext(rulesPath))
+	if rulesPath == "-" {
+		if *filter {
+			fmt.Println("filter error: --filter reads its lines from stdin, so the rules cannot come from there too")
+			return
+		}
+		rulesName = "stdin"
+	}
+	dotPath := filepath.Join(*outDir, strings.ReplaceAll(*outName, "{rules}", rulesName)+".dot")
+	if *logPath != "" && !filepath.IsAbs(*logPath) {
+		*logPath = filepath.Join(*outDir, *logPath)
+	}
+	if *configDot != "" && !filepath.IsAbs(*configDot) {
+		*configDot = filepath.Join(*outDir, *configDot)
+	}
+	if *record != "" && !filepath.IsAbs(*record) {
+		*record = filepath.Join(*outDir, *record)
+	}
 
-	raws, maxID, err := parseRules(rulesPath)
+	// out receives the dump and the trace: stdout, or the --log file
+	out := io.Writer(os.Stdout)
+	logging := *logPath != ""
+	if logging {
+		f, err := os.Create(*logPath)
+		if err != nil {
+			fmt.Println("log error:", err)
+			return
+		}
+		defer f.Close()
+		out = f
+		*stepMode = false
+	}
+	*quiet = *quiet || *asJSON
+	show := (!*quiet && *traceTail == 0) || logging
+
+	tr, err := newTracer(out, *traceTmpl, !logging && !*noColor && useColor(os.Stdout))
 	if err != nil {
-		fmt.Println("parse error:", err)
+		fmt.Println("trace error:", err)
+		return
+	}
+	switch *view {
+	case "line":
+	case "box":
+		tr.box, tr.inPlace = true, !logging && isTerminal(os.Stdout)
+	default:
+		fmt.Printf("trace error: unknown view %q (want line or box)\n", *view)
+		return
+	}
+	// jsonTrace: out gets JSON lines only, so no dump and no text around them
+	jsonTrace := false
+	switch *traceFormat {
+	case "text":
+	case "json":
+		for flag, set := range map[string]bool{"--trace-template": *traceTmpl != "", "--view box": tr.box, "--visits": *visits, "--step": *stepMode, "--debug": *debugPath != ""} {
+			if set {
+				fmt.Printf("trace error: %s writes text; it does not mix with --trace-format json\n", flag)
+				return
+			}
+		}
+		tr.enc = json.NewEncoder(out)
+		jsonTrace = show
+	default:
+		fmt.Printf("trace error: unknown trace format %q (want text or json)\n", *traceFormat)
 		return
 	}
 
-	states, start, err := buildGraph(raws, maxID)
+	diags := io.Writer(os.Stdout)
+	if *asJSON || jsonTrace && !logging {
+		diags = os.Stderr
+	}
+	m, err := load(rulesPath, *strict, diags)
 	if err != nil {
-		fmt.Println("build error:", err)
+		fmt.Println(err)
+		return
+	}
+	if *prune {
+		pruneDead(m)
+	}
+	var monitor *machine.Machine
+	if *monitorPath != "" {
+		if monitor, err = load(*monitorPath, *strict, diags); err != nil {
+			fmt.Println("monitor:", err)
+			return
+		}
+		if monitor.Kind != machine.OneWay || monitor.Nondet {
+			fmt.Println("monitor: must be a dfa (kind: dfa)")
+			return
+		}
+		if m.Nondet {
+			fmt.Println("monitor: the branches of an nfa read different symbols; --monitor needs a deterministic machine")
+			return
+		}
+	}
+	if *filter {
+		matched, err := filterLines(os.Stdin, os.Stdout, m, machine.Runtime{MaxSteps: *maxSteps, Monitor: monitor, Search: order, MaxDepth: *maxDepth})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "filter:", err)
+			os.Exit(2)
+		}
+		if !matched {
+			os.Exit(1)
+		}
+		return
+	}
+	if *inputs != "" {
+		tapes, err := readTapes(*inputs)
+		if err != nil {
+			fmt.Println("inputs error:", err)
+			os.Exit(2)
+		}
+		if !runBatch(os.Stdout, tapes, m, machine.Runtime{MaxSteps: *maxSteps, Monitor: monitor, Search: order, MaxDepth: *maxDepth}) {
+			os.Exit(1)
+		}
 		return
 	}
 
-	dump(states)
+	if logging && !jsonTrace {
+		fmt.Fprintf(out, "Rules: %s\n", rulesPath)
+	}
+	if show && !jsonTrace {
+		dump(out, m.States, nil)
+	}
 
-	if err := writeDOT(states, "fsm.dot"); err != nil {
+	if err := writeDOT(m, dotPath); err != nil {
 		fmt.Println("dot error:", err)
 		return
 	}
 
-	fmt.Println("DOT saved to: fsm.dot")
+	if show && !jsonTrace {
+		fmt.Fprintln(out, "DOT saved to:", dotPath)
+	}
 
-	tape, err := parseTapeArg(tapeArg)
+	tape, err := machine.ParseTape(args[1])
+	if err == nil {
+		err = m.CheckTape(tape)
+	}
 	if err != nil {
 		fmt.Println("tape error:", err)
 		return
 	}
 
-	ok, err := run(tape, start)
-	if err != nil {
-		fmt.Println("run error:", err)
-		return
+	delay := *delayFlag
+	interactive := !(*quiet || logging || *traceTail > 0)
+	if !interactive {
+		delay, *stepMode = 0, false
+		tr.quiet = !show
+		if isTerminal(os.Stderr) {
+			tr.prog = newProgress(os.Stderr)
+		}
+	}
+	pc := newPacer(os.Stdin, tr, delay, *stepMode)
+	if delay > 0 && !*stepMode && isTerminal(os.Stdin) {
+		if restore, err := pc.live(os.Stdin); err == nil {
+			defer restore()
+		}
+	}
+
+	cfg := machine.Runtime{MaxSteps: *maxSteps, Trail: 5, Monitor: monitor, Search: order, MaxDepth: *maxDepth}
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		cfg.Context = ctx
+	}
+	if *traceTail > 0 {
+		cfg.Trail = *traceTail
+	}
+	if *visits {
+		cfg.Visits = map[int]int{}
+	}
+	var fired []int
+	if *firedLines {
+		if m.Nondet {
+			fmt.Printf("lines error: the branches of an %s take different transitions; --fired-lines needs a deterministic machine\n", m.KindName())
+			return
+		}
+		cfg.OnStep = func(ev machine.StepEvent) { fired = append(fired, ev.Line) }
+	}
+	var configs *configGraph
+	if *configDot != "" {
+		configs = newConfigGraph(*configBudget)
+		onStep := cfg.OnStep
+		cfg.OnStep = func(ev machine.StepEvent) {
+			if onStep != nil {
+				onStep(ev)
+			}
+			configs.step(ev)
+		}
+	}
+	if *debugPath != "" {
+		script, err := readDebugScript(*debugPath)
+		if err != nil {
+			fmt.Println("debug error:", err)
+			return
+		}
+		onStep := cfg.OnStep
+		cfg.OnStep = func(ev machine.StepEvent) {
+			if onStep != nil {
+				onStep(ev)
+			}
+			notes, hit := script.step(ev)
+			for _, n := range notes {
+				tr.note(n)
+			}
+			if hit != "" {
+				tr.note(fmt.Sprintf("break at step %d: %s", ev.Step, hit))
+				if interactive {
+					pc.halt()
+				}
+			}
+		}
+	}
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			fmt.Println("record error:", err)
+			return
+		}
+		defer f.Close()
+		bw := bufio.NewWriter(f)
+		defer bw.Flush()
+		enc := json.NewEncoder(bw)
+		onStep := cfg.OnStep
+		cfg.OnStep = func(ev machine.StepEvent) {
+			if onStep != nil {
+				onStep(ev)
+			}
+			enc.Encode(ev)
+		}
+	}
+	res := run(tape, m, tr, pc, cfg)
+	if configs != nil {
+		if err := configs.write(*configDot, res); err != nil {
+			fmt.Println("dot error:", err)
+		}
 	}
 
-	fmt.Printf("Final: %s  =>  %s\n", tape, map[bool]string{true: "ACCEPT", false: "REJECT"}[ok])
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+		if logging {
+			enc = json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			enc.Encode(res)
+		}
+		return
+	}
+	report := func(w io.Writer) {
+		fmt.Fprintf(w, "Final: %s  =>  %s\n", tape, tr.verdict(res))
+		if !*quiet {
+			fmt.Fprintln(w, "Digest:", res.Digest)
+		}
+		if res.Accepted {
+			if len(res.Path) > 0 && !*quiet {
+				fmt.Fprintf(w, "Accepting path (%d steps):\n", len(res.Path))
+				for _, ev := range res.Path {
+					fmt.Fprintln(w, " ", stepRow(ev), " ", machine.HighlightIndex(ev.Cells, ev.Head))
+				}
+			}
+			return
+		}
+		fmt.Fprintln(w, "Reason:", res.Reason)
+		if len(res.Last) > 0 && !*quiet {
+			fmt.Fprintf(w, "Last %d steps:\n", len(res.Last))
+			for _, ev := range res.Last {
+				fmt.Fprintln(w, " ", stepRow(ev), " ", machine.HighlightIndex(ev.Cells, ev.Head))
+			}
+		}
+		if *firedLines {
+			fmt.Fprintf(w, "Rules lines fired (%d steps): %s\n", len(fired), lineRuns(fired))
+		}
+	}
+	if jsonTrace {
+		tr.enc.Encode(res)
+		if logging {
+			// stdout keeps its verdict, as with a text trace
+			report(os.Stdout)
+		}
+		return
+	}
+	report(os.Stdout)
+	if logging {
+		report(out)
+	}
+	if *visits {
+		fmt.Fprintln(out)
+		dump(out, m.States, cfg.Visits)
+	}
 }
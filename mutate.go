@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"project_twa/pkg/machine"
+)
+
+// mutant is a small change to a machine: apply makes it in place and
+// returns a function that undoes it.
+type mutant struct {
+	desc  string
+	apply func() (undo func())
+}
+
+// mutants lists the single-point changes to m: flipping whether a state
+// accepts, sending one transition to another state, and (2dfa) reversing
+// a direction.
+func mutants(m *machine.Machine) []mutant {
+	var live []*machine.State
+	for _, s := range m.States {
+		if s.Defined && !s.Implicit {
+			live = append(live, s)
+		}
+	}
+	var out []mutant
+	add := func(desc string, apply func() func()) {
+		out = append(out, mutant{desc, apply})
+	}
+
+	for _, s := range live {
+		s := s
+		switch {
+		case s.Accept != s.Reject:
+			now := "accepts"
+			if s.Accept {
+				now = "rejects"
+			}
+			add(fmt.Sprintf("state %d %s instead", s.ID, now), func() func() {
+				s.Accept, s.Reject = s.Reject, s.Accept
+				return func() { s.Accept, s.Reject = s.Reject, s.Accept }
+			})
+		case m.Kind == machine.OneWay:
+			now := "accepting"
+			if s.Final {
+				now = "not accepting"
+			}
+			add(fmt.Sprintf("state %d is %s", s.ID, now), func() func() {
+				s.Final = !s.Final
+				return func() { s.Final = !s.Final }
+			})
+		}
+	}
+
+	for _, s := range live {
+		s := s
+		for _, sym := range s.Order {
+			sym := sym
+			e := s.Next[sym]
+			for _, t := range live {
+				if t == e.To {
+					continue
+				}
+				t := t
+				add(fmt.Sprintf("state %d on %c goes to %d instead of %d", s.ID, sym, t.ID, e.To.ID), func() func() {
+					f := e
+					f.To = t
+					s.Next[sym] = f
+					return func() { s.Next[sym] = e }
+				})
+			}
+			if e.Dir != 0 && m.Kind == machine.TwoWay {
+				add(fmt.Sprintf("state %d on %c moves %s instead of %s", s.ID, sym, -e.Dir, e.Dir), func() func() {
+					f := e
+					f.Dir = -e.Dir
+					s.Next[sym] = f
+					return func() { s.Next[sym] = e }
+				})
+			}
+		}
+		if s.Other != nil && !s.Other.Implicit {
+			e := *s.Other
+			for _, t := range live {
+				if t == e.To {
+					continue
+				}
+				t := t
+				add(fmt.Sprintf("state %d on * goes to %d instead of %d", s.ID, t.ID, e.To.ID), func() func() {
+					f := e
+					f.To = t
+					s.Other = &f
+					return func() { s.Other = &e }
+				})
+			}
+		}
+		if m.Kind == machine.TwoWay && !s.Accept && !s.Reject && (len(s.Next) > 0 || s.Other != nil) {
+			add(fmt.Sprintf("state %d moves %s instead of %s", s.ID, -s.Dir, s.Dir), func() func() {
+				s.Dir = -s.Dir
+				return func() { s.Dir = -s.Dir }
+			})
+		}
+	}
+	return out
+}
+
+// mutateCmd measures how strong a test suite is: it runs the suite on
+// every mutant of the machine and reports the mutants no case tells
+// apart from the original.
+func mutateCmd(args []string) {
+	fs := flag.NewFlagSet("mutate", flag.ContinueOnError)
+	suitePath := fs.String("suite", "", "test suite `file`: \"#tape# accept|reject\" lines")
+	maxSteps := fs.Int("max-steps", 10000, "step bound for each run")
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 || *suitePath == "" {
+		fmt.Println("Usage: go run . mutate --suite <tests.txt> [flags] <rules.txt>")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := loadDeterministic(args[0], *strict, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	cases, _, err := readSuite(*suitePath)
+	if err != nil {
+		fmt.Println("suite error:", err)
+		os.Exit(2)
+	}
+
+	cfg := machine.Runtime{MaxSteps: *maxSteps}
+	// passes reports whether the machine, as it is now, passes every case
+	passes := func() bool {
+		for _, c := range cases {
+			if runSilent(c.tape, m, cfg).Accepted != c.accept {
+				return false
+			}
+		}
+		return true
+	}
+	if !passes() {
+		fmt.Printf("%s fails the suite itself: fix it before judging the suite\n", args[0])
+		os.Exit(1)
+	}
+
+	all := mutants(m)
+	var survivors []string
+	for _, mu := range all {
+		undo := mu.apply()
+		if passes() {
+			survivors = append(survivors, mu.desc)
+		}
+		undo()
+	}
+	killed := len(all) - len(survivors)
+	score := 100.0
+	if len(all) > 0 {
+		score = 100 * float64(killed) / float64(len(all))
+	}
+	fmt.Printf("%d mutants, %d killed, %d survived (score %.0f%%)\n", len(all), killed, len(survivors), score)
+	for _, s := range survivors {
+		fmt.Println("  survived:", s)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"project_twa/pkg/machine"
+)
+
+// pacer decides how the run loop waits between steps: a fixed delay, or,
+// in step mode, a prompt that waits for the user. With live keys attached
+// the delay can be changed while the run animates; the embedded
+// machine.Pacer does that waiting, its Keys nil unless attached.
+type pacer struct {
+	machine.Pacer
+	step bool
+	in   *bufio.Reader
+	tr   *tracer
+}
+
+func newPacer(in io.Reader, tr *tracer, delay time.Duration, step bool) *pacer {
+	p := &pacer{step: step, in: bufio.NewReader(in), tr: tr}
+	p.Delay = delay
+	p.OnChange = func() {
+		if p.Paused {
+			p.tr.note("paused (space resumes)")
+			return
+		}
+		p.tr.note(fmt.Sprintf("delay %v", p.Delay))
+	}
+	return p
+}
+
+// wait blocks until the next step may run. In step mode Enter runs one
+// step, "c" continues without prompting and "q" stops the run.
+func (p *pacer) wait() error {
+	if !p.step {
+		return p.Pacer.Wait()
+	}
+	p.tr.prompt("[Enter] step  [c] continue  [q] quit: ")
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		// input closed: nobody left to answer, run freely
+		p.step = false
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "q":
+		return machine.ErrStopped
+	case "c":
+		p.step = false
+	}
+	return nil
+}
+
+// halt pauses the run before its next step, as a breakpoint does: with
+// live keys it pauses until space, otherwise it switches to step mode.
+func (p *pacer) halt() {
+	if p.Keys != nil {
+		p.Paused = true
+		return
+	}
+	p.step = true
+}
+
+// live puts the terminal f into raw mode and reads single keys from it:
+// "+" halves the delay, "-" doubles it, space pauses and resumes, "q"
+// stops. The returned function restores the terminal.
+func (p *pacer) live(f *os.File) (func(), error) {
+	restore, err := makeRaw(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := f.Read(buf); err != nil || n == 0 {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	// an interrupt must not leave the terminal without echo
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		restore()
+		os.Exit(130)
+	}()
+	p.Keys = keys
+	return func() {
+		signal.Stop(sig)
+		restore()
+	}, nil
+}
@@ -0,0 +1,246 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The errors a run's Wait returns to end it: ErrStopped when the user
+// stops it, ErrEnded when its context is done.
+var (
+	ErrStopped = errors.New("stopped by user")
+	ErrEnded   = errors.New("run ended")
+)
+
+const (
+	minDelay = 10 * time.Millisecond
+	maxDelay = 10 * time.Second
+)
+
+// Pacer is the wait between the steps of a run that animates: a delay,
+// which control keys can change while the run goes, and a pause. Its Wait
+// is a Runtime's Wait; RunAsync and the command line's live keys use it.
+type Pacer struct {
+	Delay  time.Duration
+	Paused bool
+	// Keys, if set, delivers control keys: space pauses and resumes, "q"
+	// stops, "+" halves the delay and "-" doubles it. Others are ignored.
+	Keys <-chan byte
+	// Done, if set, ends the wait with ErrEnded once closed.
+	Done <-chan struct{}
+	// OnChange, if set, is called after a key changed Delay or Paused.
+	OnChange func()
+}
+
+// Wait holds the run before its next step: for Delay, and while Paused,
+// obeying the keys meanwhile. It returns the error that ends the run, if
+// a key or Done says so.
+func (p *Pacer) Wait() error {
+	if p.Keys == nil && !p.Paused && p.Delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(p.Delay)
+	defer timer.Stop()
+	for {
+		var tick <-chan time.Time
+		if !p.Paused {
+			tick = timer.C
+		}
+		select {
+		case <-tick:
+			return nil
+		case <-p.Done:
+			return ErrEnded
+		case k := <-p.Keys:
+			delay, paused := p.Delay, p.Paused
+			if err := p.Key(k); err != nil {
+				return err
+			}
+			if p.Delay != delay || p.Paused != paused {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(p.Delay)
+			}
+		}
+	}
+}
+
+// Key applies a control key, as Wait does with those from Keys, and
+// returns ErrStopped for "q".
+func (p *Pacer) Key(k byte) error {
+	switch k {
+	case '+':
+		if p.Delay /= 2; p.Delay < minDelay {
+			p.Delay = 0
+		}
+	case '-':
+		p.Delay = min(max(p.Delay*2, minDelay), maxDelay)
+	case ' ':
+		p.Paused = !p.Paused
+	case 'q':
+		return ErrStopped
+	default:
+		return nil
+	}
+	if p.OnChange != nil {
+		p.OnChange()
+	}
+	return nil
+}
+
+// RunOptions configure RunAsync.
+type RunOptions struct {
+	MaxSteps int           // step cap; 0 keeps the machine's
+	Trail    int           // last steps kept in the result; 0 keeps the machine's
+	Delay    time.Duration // wait between steps, for animating
+	Steps    bool          // stream every step on Handle.Steps
+	// Context, if set, ends the run once done: with the outcome TimedOut
+	// past its deadline, Stopped if cancelled. Unset keeps the machine's.
+	Context context.Context
+}
+
+// Handle controls a run started by RunAsync. Its methods may be called
+// from any goroutine; once the run is over they do nothing.
+type Handle struct {
+	keys   chan byte // Pacer keys
+	done   chan struct{}
+	steps  chan StepEvent
+	res    Result
+	mu     sync.Mutex
+	paused bool
+}
+
+// RunAsync starts running the machine on tape ("#...#") in its own
+// goroutine and returns at once. The run is configured by the machine's
+// Defaults, as Run's, with opts over them; the Wait and OnStep hooks
+// there still run, besides RunAsync's. Pause, Resume and Stop act between
+// steps, like the live keys of the command line.
+func (m *Machine) RunAsync(tape string, opts RunOptions) (*Handle, error) {
+	tape, err := ParseTape(tape)
+	if err == nil {
+		err = m.CheckTape(tape)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handle{keys: make(chan byte), done: make(chan struct{})}
+	// the machine's defaults (search order, depth, hooks), with the
+	// options over them
+	rt := m.Defaults
+	if opts.MaxSteps > 0 {
+		rt.MaxSteps = opts.MaxSteps
+	}
+	if rt.MaxSteps <= 0 {
+		rt.MaxSteps = DefaultMaxSteps
+	}
+	if opts.Trail > 0 {
+		rt.Trail = opts.Trail
+	}
+	if opts.Context != nil {
+		rt.Context = opts.Context
+	}
+	// Only the run's goroutine touches pace, and stop: why the run must
+	// end, if that came while a step was being delivered.
+	pace := &Pacer{Delay: opts.Delay, Keys: h.keys}
+	if rt.Context != nil {
+		pace.Done = rt.Context.Done()
+	}
+	var stop error
+	if opts.Steps {
+		h.steps = make(chan StepEvent, 64)
+		onStep := rt.OnStep
+		rt.OnStep = func(ev StepEvent) {
+			if onStep != nil {
+				onStep(ev)
+			}
+			if stop == nil {
+				stop = h.deliver(ev, pace)
+			}
+		}
+	}
+	wait := rt.Wait
+	rt.Wait = func() error {
+		if stop != nil {
+			return stop
+		}
+		if err := pace.Wait(); err != nil || wait == nil {
+			return err
+		}
+		return wait()
+	}
+	go func() {
+		h.res = rt.Run(m, tape)
+		if h.steps != nil {
+			close(h.steps)
+		}
+		close(h.done)
+	}()
+	return h, nil
+}
+
+// deliver sends a step on Steps, waiting while the buffer is full. Keys
+// and the context are still obeyed meanwhile: it returns the error that
+// ends the run if Stop is called or the context is done before the
+// reader takes the step, which is then dropped.
+func (h *Handle) deliver(ev StepEvent, pace *Pacer) error {
+	for {
+		select {
+		case h.steps <- ev:
+			return nil
+		case <-pace.Done:
+			return ErrEnded
+		case k := <-h.keys:
+			if err := pace.Key(k); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Result waits for the run to end and returns its result.
+func (h *Handle) Result() Result {
+	<-h.done
+	return h.res
+}
+
+// Done is closed when the run ends.
+func (h *Handle) Done() <-chan struct{} { return h.done }
+
+// Steps delivers every step as it is taken and is closed when the run
+// ends; nil unless RunOptions.Steps was set. The run waits for the reader
+// once the buffer is full; Stop, Pause and the Context still act while it
+// waits, and a Stop or an ended Context drops the pending step.
+func (h *Handle) Steps() <-chan StepEvent { return h.steps }
+
+// Pause holds the run before its next step.
+func (h *Handle) Pause() { h.toggle(true) }
+
+// Resume lets a paused run go on.
+func (h *Handle) Resume() { h.toggle(false) }
+
+// Stop ends the run before its next step, with the outcome Stopped.
+func (h *Handle) Stop() { h.send('q') }
+
+func (h *Handle) toggle(pause bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.paused != pause && h.send(' ') {
+		h.paused = pause
+	}
+}
+
+func (h *Handle) send(k byte) bool {
+	select {
+	case h.keys <- k:
+		return true
+	case <-h.done:
+		return false
+	}
+}
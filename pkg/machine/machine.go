@@ -0,0 +1,398 @@
+// Package machine is the simulator behind the command line: it parses
+// rules from a file or any io.Reader (ParseRules, ParseRulesFrom,
+// Validate), builds them into a Machine (BuildGraph, or NewMachine from
+// states built in Go) and runs tapes on it (Runtime.Run, Machine.Run,
+// Machine.RunAsync), so other Go programs can embed it.
+package machine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Move int8
+
+const (
+	L Move = -1
+	R Move = +1
+)
+
+type StepStatus int
+
+const (
+	Continue StepStatus = iota
+	Accept
+	Reject
+)
+
+func (st StepStatus) String() string {
+	switch st {
+	case Accept:
+		return "accept"
+	case Reject:
+		return "reject"
+	}
+	return "continue"
+}
+
+type Edge struct {
+	To       *State
+	Dir      Move // 0: move the way the target state does
+	Implicit bool // added by an on-missing policy, not written in the rules
+	Line     int  // rules-file line the pair was written on; 0 if none
+}
+
+func (e Edge) Move() Move {
+	if e.Dir != 0 {
+		return e.Dir
+	}
+	return e.To.Dir
+}
+
+type State struct {
+	ID    int
+	Dir   Move
+	Next  map[uint8]Edge
+	Order []uint8 // the symbols of Next in rules-file order; iterate this, not Next
+	Other *Edge   // wildcard edge, taken on symbols without their own edge
+	// In an nfa, the further choices after Next's edge on a symbol, and
+	// after Other, in rules-file order.
+	Alts      map[uint8][]Edge
+	OtherAlts []Edge
+	Eps       []Edge // nfa: moves to take without reading, the head staying
+	Accept    bool
+	Reject    bool
+	Final     bool // kind dfa: accepting if the input ends in this state
+	// Defined is set for ids that have a line in the rules; the others
+	// are gaps in the numbering.
+	Defined bool
+	// Lines are the rules-file lines that define the state, in order.
+	Lines []int
+	// Implicit states are added by an on-missing policy.
+	Implicit bool
+}
+
+// Where names the rules-file lines that define s, for messages: "line 4",
+// "lines 2 and 9", or "" for a state no line defines.
+func (s *State) Where() string {
+	n := len(s.Lines)
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("line %d", s.Lines[0])
+	}
+	parts := make([]string, n-1)
+	for k, ln := range s.Lines[:n-1] {
+		parts[k] = strconv.Itoa(ln)
+	}
+	return fmt.Sprintf("lines %s and %d", strings.Join(parts, ", "), s.Lines[n-1])
+}
+
+// at is Where as a parenthesized suffix, " (line 4)", or "".
+func (s *State) at() string {
+	if w := s.Where(); w != "" {
+		return " (" + w + ")"
+	}
+	return ""
+}
+
+// SetEdge makes e the transition on sym. A symbol new to the state goes
+// last in Order; one it had keeps its place.
+func (s *State) SetEdge(sym byte, e Edge) {
+	if s.Next == nil {
+		s.Next = make(map[uint8]Edge)
+	}
+	if _, ok := s.Next[sym]; !ok {
+		s.Order = append(s.Order, sym)
+	}
+	s.Next[sym] = e
+}
+
+// RemoveEdge drops the transition on sym, if any.
+func (s *State) RemoveEdge(sym byte) {
+	if _, ok := s.Next[sym]; !ok {
+		return
+	}
+	delete(s.Next, sym)
+	delete(s.Alts, sym)
+	for k, o := range s.Order {
+		if o == sym {
+			s.Order = append(s.Order[:k], s.Order[k+1:]...)
+			break
+		}
+	}
+}
+
+func (s *State) EdgeOn(sym byte) (Edge, error) {
+
+	if e, ok := s.Next[sym]; ok {
+		return e, nil
+	}
+	if s.Other != nil {
+		return *s.Other, nil
+	}
+	return Edge{}, fmt.Errorf("invalid symbol %q", sym)
+}
+
+// EdgesOn lists every transition s may take on sym: one at most in a
+// deterministic machine, all of its choices in an nfa.
+func (s *State) EdgesOn(sym byte) []Edge {
+	if e, ok := s.Next[sym]; ok {
+		return append([]Edge{e}, s.Alts[sym]...)
+	}
+	if s.Other != nil {
+		return append([]Edge{*s.Other}, s.OtherAlts...)
+	}
+	return nil
+}
+
+func (s *State) Step(t Tape, i int) (*State, int, StepStatus, error) {
+
+	e, err := s.EdgeOn(t.Read(i))
+	if err != nil {
+		return nil, i, Continue, err
+	}
+	nxt := e.To
+	if nxt == nil {
+		return nil, i, Continue, fmt.Errorf("missing transition: state %d on %q", s.ID, t.Read(i))
+	}
+	if nxt.Accept {
+		return nxt, i, Accept, nil
+	}
+	if nxt.Reject {
+		return nxt, i, Reject, nil
+	}
+	return nxt, t.Move(i, e.Move()), Continue, nil
+}
+
+func (m Move) String() string {
+	if m == L {
+		return "L"
+	}
+	return "R"
+}
+
+// MissingPolicy says what happens when a state has no transition for the
+// symbol under the head.
+type MissingPolicy int
+
+const (
+	MissingError      MissingPolicy = iota // the run gets stuck
+	MissingRejectSink                      // the run goes to an implicit reject state
+	MissingIgnore                          // the symbol is skipped: same state, head moves on
+)
+
+// Kind is the kind of automaton a rules file describes.
+type Kind int
+
+const (
+	TwoWay Kind = iota // 2dfa: the head moves both ways; accept and reject states halt on entry
+	OneWay             // dfa: the head only moves right; the input is accepted if it ends in an accept state
+)
+
+func (k Kind) String() string {
+	if k == OneWay {
+		return "dfa"
+	}
+	return "2dfa"
+}
+
+// BoundsPolicy says what happens when the head moves off the tape, past
+// an endmarker.
+type BoundsPolicy int
+
+const (
+	BoundsError  BoundsPolicy = iota // the run ends out of bounds
+	BoundsReject                     // the run rejects
+	BoundsClamp                      // the head stays on the endmarker
+)
+
+func (b BoundsPolicy) String() string {
+	return [...]string{"error", "reject", "clamp"}[b]
+}
+
+// Machine is a built rules file: its states indexed by id (index 0 is
+// unused) and the start state. NewMachine makes one from options.
+type Machine struct {
+	Kind             Kind
+	States           []*State
+	Start            *State
+	DeclaredAlphabet string // declared input alphabet, or ""
+	OnBounds         BoundsPolicy
+	Bounce           bool // '#' is only an endmarker
+	Groups           []Group
+	Name             string  // base name of the rules file, or ""
+	Nondet           bool    // an nfa or 2nfa: a run searches every choice
+	Defaults         Runtime // how Run runs the machine
+}
+
+// KindName is the kind as a rules file names it: dfa, 2dfa, nfa or 2nfa.
+func (m *Machine) KindName() string {
+	if m.Nondet {
+		return strings.Replace(m.Kind.String(), "dfa", "nfa", 1)
+	}
+	return m.Kind.String()
+}
+
+// Alphabet is the declared input alphabet, or else the symbols the machine
+// has transitions on. open is true when no alphabet was declared and some
+// state takes any symbol (a "*" pair or an on-missing fallback), so every
+// symbol is readable.
+func (m *Machine) Alphabet() (alphabet string, open bool) {
+	if m.DeclaredAlphabet != "" {
+		return m.DeclaredAlphabet, false
+	}
+	for _, s := range m.States {
+		if s != nil && s.Other != nil {
+			open = true
+		}
+	}
+	return InputSymbols(m.States), open
+}
+
+// CheckTape reports the first cell between the endmarkers whose symbol is
+// not in the machine's alphabet, or is a '#' where only the ends may be.
+func (m *Machine) CheckTape(tape string) error {
+	if m.Bounce {
+		if i := strings.IndexByte(tape[1:len(tape)-1], '#'); i >= 0 {
+			return fmt.Errorf("'#' at position %d: with endmarkers: bounce it only marks the ends", i+1)
+		}
+	}
+	alphabet, open := m.Alphabet()
+	if open {
+		return nil
+	}
+	for i := 1; i < len(tape)-1; i++ {
+		if tape[i] != '#' && strings.IndexByte(alphabet, tape[i]) < 0 {
+			return fmt.Errorf("symbol %q at position %d is not in the alphabet {%s}", tape[i:i+1], i, strings.Join(strings.Split(alphabet, ""), ","))
+		}
+	}
+	return nil
+}
+
+// BuildGraph turns rules that passed Validate into a Machine, applying
+// priorities and the on-missing policy.
+func BuildGraph(rs *Rules) (*Machine, error) {
+
+	st := make([]*State, rs.MaxID+1)
+	for i := 0; i <= rs.MaxID; i++ {
+		st[i] = &State{ID: i, Dir: R}
+	}
+
+	// priority of the edge currently kept for each (state, symbol)
+	kept := map[[2]int]int{}
+	for _, ln := range rs.Lines {
+		s := st[ln.ID]
+		s.Defined = true
+		s.Lines = append(s.Lines, ln.Line)
+		if ln.Accept && rs.Kind == OneWay {
+			s.Final = true
+		} else if ln.Accept {
+			s.Accept = true
+		}
+		if ln.Reject {
+			s.Reject = true
+		}
+		if len(ln.Pairs) > 0 {
+			s.Dir = ln.Dir
+		}
+		for _, p := range ln.Pairs {
+			e := Edge{To: st[p.To], Dir: p.Dir, Line: ln.Line}
+			if p.Eps {
+				s.Eps = append(s.Eps, e)
+				continue
+			}
+			if rs.Nondet {
+				// every pair is a choice; a second one on a symbol goes
+				// to the alternatives
+				switch _, ok := s.Next[p.Sym[0]]; {
+				case p.Wild && s.Other != nil:
+					s.OtherAlts = append(s.OtherAlts, e)
+					continue
+				case !p.Wild && ok:
+					if s.Alts == nil {
+						s.Alts = make(map[uint8][]Edge)
+					}
+					s.Alts[p.Sym[0]] = append(s.Alts[p.Sym[0]], e)
+					continue
+				}
+			}
+			// the lowest priority wins; among equal ones, which only a
+			// version 1 file without priorities can have, the last
+			key := [2]int{ln.ID, int(p.Sym[0])}
+			if prio, ok := kept[key]; ok && prio < p.Prio {
+				continue
+			}
+			kept[key] = p.Prio
+			if p.Wild {
+				s.Other = &e
+				continue
+			}
+			s.SetEdge(p.Sym[0], e)
+		}
+
+	}
+
+	return NewMachine(rs.Kind, st, st[1],
+		WithAlphabet(rs.Alphabet),
+		WithBounds(rs.OnBounds),
+		WithBounce(rs.Bounce),
+		WithGroups(rs.Groups...),
+		WithNondet(rs.Nondet),
+		WithMissing(rs.OnMissing),
+	), nil
+}
+
+// InputSymbols lists, sorted, the symbols the machine has transitions on,
+// leaving out the '#' endmarker.
+func InputSymbols(states []*State) string {
+	seen := map[byte]bool{}
+	for _, s := range states {
+		if s == nil {
+			continue
+		}
+		for _, sym := range s.Order {
+			if sym != '#' {
+				seen[sym] = true
+			}
+		}
+	}
+	syms := make([]byte, 0, len(seen))
+	for sym := range seen {
+		syms = append(syms, sym)
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i] < syms[j] })
+	return string(syms)
+}
+
+// Implicit reports whether an on-missing policy added states or edges.
+func (m *Machine) Implicit() bool {
+	for _, s := range m.States {
+		if s != nil && (s.Implicit || s.Other != nil && s.Other.Implicit) {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome says how a run ended.
+type Outcome int
+
+const (
+	Accepted    Outcome = iota
+	Rejected            // entered a reject state
+	Stuck               // no transition for the symbol under the head
+	OutOfBounds         // the head moved off the tape
+	StepLimit           // the step cap was reached
+	Looped              // a configuration repeated, so the run never halts
+	Stopped             // the user stopped the run
+	Violated            // the monitor entered a reject state
+	TimedOut            // the run's context deadline passed
+)
+
+func (o Outcome) String() string {
+	return [...]string{"accepted", "rejected", "stuck", "out of bounds", "step limit", "looped", "stopped", "violated", "timed out"}[o]
+}
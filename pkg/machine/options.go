@@ -0,0 +1,165 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// DefaultMaxSteps is the step cap of a run that does not set one.
+const DefaultMaxSteps = 1000000
+
+// Option sets up a machine made by NewMachine: how it reads its tape (the
+// alphabet, the policies) or how Machine.Run runs it (limits and hooks).
+// Options apply in order, so a later one overrides an earlier one.
+type Option func(*Machine)
+
+// NewMachine makes a machine of kind from states indexed by id (index 0
+// unused), starting in start. BuildGraph makes every machine of a rules
+// file with it; programs that build their states in Go use it too.
+func NewMachine(kind Kind, states []*State, start *State, opts ...Option) *Machine {
+	m := &Machine{Kind: kind, States: states, Start: start}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Run runs m on tape as its Defaults say; see Runtime.Run.
+func (m *Machine) Run(tape string) Result {
+	cfg := m.Defaults
+	if cfg.MaxSteps <= 0 {
+		cfg.MaxSteps = DefaultMaxSteps
+	}
+	return cfg.Run(m, tape)
+}
+
+// WithAlphabet declares the input alphabet; tapes with other symbols are
+// refused by CheckTape.
+func WithAlphabet(alphabet string) Option {
+	return func(m *Machine) { m.DeclaredAlphabet = alphabet }
+}
+
+// WithBounds sets what happens when the head moves off the tape.
+func WithBounds(p BoundsPolicy) Option {
+	return func(m *Machine) { m.OnBounds = p }
+}
+
+// WithBounce makes '#' only an endmarker, refused inside a tape.
+func WithBounce(on bool) Option {
+	return func(m *Machine) { m.Bounce = on }
+}
+
+// WithNondet sets the acceptance mode: a nondeterministic machine
+// accepts if any branch of its run does, and its states may have several
+// choices on a symbol.
+func WithNondet(on bool) Option {
+	return func(m *Machine) { m.Nondet = on }
+}
+
+// WithGroups sets the state groups DOT output draws as clusters.
+func WithGroups(groups ...Group) Option {
+	return func(m *Machine) { m.Groups = groups }
+}
+
+// WithName names the machine, as the command line does after its file.
+func WithName(name string) Option {
+	return func(m *Machine) { m.Name = name }
+}
+
+// WithMissing applies an on-missing policy to the states: MissingRejectSink
+// adds an implicit reject state that every missing transition goes to,
+// MissingIgnore a self-loop on each state. Give it after the states are
+// complete, as NewMachine's states are.
+func WithMissing(p MissingPolicy) Option {
+	return func(m *Machine) {
+		switch p {
+		case MissingRejectSink:
+			// Every symbol a state has no edge for, in the alphabet or
+			// not, leads to one extra reject state.
+			sink := &State{ID: len(m.States), Dir: R, Reject: true, Defined: true, Implicit: true}
+			for _, s := range m.States {
+				if s != nil && s.Defined && !s.Accept && !s.Reject && s.Other == nil {
+					s.Other = &Edge{To: sink, Implicit: true}
+				}
+			}
+			m.States = append(m.States, sink)
+		case MissingIgnore:
+			// A self-loop moves the head the state's own way.
+			for _, s := range m.States {
+				if s != nil && s.Defined && !s.Accept && !s.Reject && s.Other == nil {
+					s.Other = &Edge{To: s, Implicit: true}
+				}
+			}
+		}
+	}
+}
+
+// WithMaxSteps caps the steps of a run (0: DefaultMaxSteps).
+func WithMaxSteps(n int) Option {
+	return func(m *Machine) { m.Defaults.MaxSteps = n }
+}
+
+// WithTrail keeps the last n steps of a run in its result.
+func WithTrail(n int) Option {
+	return func(m *Machine) { m.Defaults.Trail = n }
+}
+
+// WithSearch sets the order a run of a nondeterministic machine explores
+// its choices in, and cuts off branches longer than maxDepth steps if
+// maxDepth is positive.
+func WithSearch(order SearchOrder, maxDepth int) Option {
+	return func(m *Machine) { m.Defaults.Search, m.Defaults.MaxDepth = order, maxDepth }
+}
+
+// WithContext ends runs once ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(m *Machine) { m.Defaults.Context = ctx }
+}
+
+// WithDelay waits d before each step after the first, for animating. A
+// context given with WithContext cuts the wait short when it is done.
+func WithDelay(d time.Duration) Option {
+	return func(m *Machine) {
+		wait := m.Defaults.Wait
+		m.Defaults.Wait = func() error {
+			var ended <-chan struct{}
+			if ctx := m.Defaults.Context; ctx != nil {
+				ended = ctx.Done()
+			}
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ended:
+				return m.Defaults.Context.Err()
+			}
+			if wait != nil {
+				return wait()
+			}
+			return nil
+		}
+	}
+}
+
+// WithObserver calls f with every step as it is taken, after the
+// observers given before it.
+func WithObserver(f func(ev StepEvent)) Option {
+	return func(m *Machine) {
+		onStep := m.Defaults.OnStep
+		m.Defaults.OnStep = func(ev StepEvent) {
+			if onStep != nil {
+				onStep(ev)
+			}
+			f(ev)
+		}
+	}
+}
+
+// WithWriter writes every step to w as a JSON line, the format of the
+// command line's --trace-format json.
+func WithWriter(w io.Writer) Option {
+	enc := json.NewEncoder(w)
+	return WithObserver(func(ev StepEvent) { enc.Encode(ev) })
+}
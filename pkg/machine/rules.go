@@ -0,0 +1,366 @@
+package machine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Pair struct {
+	Sym  string
+	To   int
+	Dir  Move // version 2+: per-transition direction, 0 if not given
+	Wild bool // version 2+: "*" matches any symbol without its own pair
+	Eps  bool // kind nfa or 2nfa: (ε,to) or (_,to) changes state without reading
+	Prio int  // version 2+: (sym,to)!prio, 1 first; 0 if not given
+	Col  int  // column of the '('
+}
+
+type Line struct {
+	Line   int
+	Col    int
+	ID     int
+	Dir    Move
+	Pairs  []Pair
+	Accept bool
+	Reject bool
+}
+
+func parseMoveLR(s string) (Move, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "left", "l":
+		return L, true
+	case "right", "r":
+		return R, true
+	default:
+		return 0, false
+	}
+}
+
+func ParseStateID(s string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if id < 1 {
+		return 0, fmt.Errorf("state id must be positive, got %d", id)
+	}
+	return id, nil
+}
+
+// Rules is a parsed rules file: its header directives and state lines.
+type Rules struct {
+	Version   int
+	Kind      Kind
+	OnMissing MissingPolicy
+	OnBounds  BoundsPolicy
+	Bounce    bool   // "endmarkers: bounce": '#' only at the ends, never passed
+	Nondet    bool   // kind nfa or 2nfa: pairs on the same symbol are choices
+	Alphabet  string // declared with "alphabet:", sorted; "" if not declared
+	Lines     []Line
+	Groups    []Group
+	MaxID     int
+}
+
+// Group is an "@group name id..." line: states drawn together as one
+// cluster in the DOT export, typically a phase of the machine.
+type Group struct {
+	Name string
+	IDs  []int
+	Line int
+	Cols []int // column of each id
+}
+
+// MaxVersion is the newest rules format understood. Files without a
+// "version: N" header are version 1: every pair is (sym,to) and moves the
+// way its target state does. Version 2 adds (sym,to,dir) pairs that carry
+// their own direction, the "*" wildcard symbol, and !N priorities that
+// decide between pairs on the same symbol.
+const MaxVersion = 2
+
+// ParseRules reads a rules file. In strict mode, text the parser would
+// otherwise skip over (stray tokens around pairs, extra words on accept and
+// reject lines) is an error.
+func ParseRules(path string, strict bool) (*Rules, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ParseRulesFrom(f, strict)
+}
+
+// ParseRulesFrom is ParseRules on rules text from r.
+func ParseRulesFrom(r io.Reader, strict bool) (*Rules, error) {
+
+	var lines []Line
+	maxID := 0
+	version := 1
+	rs := &Rules{}
+	sc := bufio.NewScanner(r)
+	ln, lead := 0, 0
+
+	// fail reports a problem at byte offset at of the trimmed line.
+	fail := func(at int, code, format string, args ...any) (*Rules, error) {
+		return nil, Diagnostic{Line: ln, Column: lead + at + 1, Severity: SevError, Code: code, Message: fmt.Sprintf(format, args...)}
+	}
+
+	for sc.Scan() {
+		ln++
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
+		lead = len(raw) - len(strings.TrimLeft(raw, " \t\r\n\v\f"))
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		// @group name id id ...
+		if strings.HasPrefix(line, "@") {
+			fields := strings.Fields(line)
+			if fields[0] != "@group" {
+				return fail(0, "unknown-directive", "unknown directive %q", fields[0])
+			}
+			if len(fields) < 3 {
+				return fail(0, "bad-group", "expect @group <name> <state>...")
+			}
+			g := Group{Name: fields[1], Line: ln}
+			at := strings.Index(line, fields[1]) + len(fields[1])
+			for _, f := range fields[2:] {
+				at += strings.Index(line[at:], f)
+				id, e := ParseStateID(f)
+				if e != nil {
+					return fail(at, "bad-state", "%v", e)
+				}
+				g.IDs = append(g.IDs, id)
+				g.Cols = append(g.Cols, lead+at+1)
+				at += len(f)
+			}
+			rs.Groups = append(rs.Groups, g)
+			continue
+		}
+		// key: value header directives; the key, the first token, has no
+		// ']', which would make it a state id
+		if key, val, ok := strings.Cut(line, ":"); ok && !strings.Contains(key, "]") {
+			if len(lines) > 0 {
+				return fail(0, "late-directive", "%q must come before the states", strings.TrimSpace(key))
+			}
+			valAt := len(line) - len(strings.TrimLeft(val, " \t"))
+			val = strings.TrimSpace(val)
+			switch strings.TrimSpace(key) {
+			case "version":
+				n, e := strconv.Atoi(val)
+				if e != nil || n < 1 || n > MaxVersion {
+					return fail(valAt, "bad-version", "unsupported rules version %q", val)
+				}
+				version = n
+			case "kind":
+				switch val {
+				case "2dfa":
+					rs.Kind, rs.Nondet = TwoWay, false
+				case "dfa":
+					rs.Kind, rs.Nondet = OneWay, false
+				case "2nfa":
+					rs.Kind, rs.Nondet = TwoWay, true
+				case "nfa":
+					rs.Kind, rs.Nondet = OneWay, true
+				default:
+					return fail(valAt, "bad-kind", "kind must be 2dfa, dfa, 2nfa or nfa, got %q", val)
+				}
+			case "alphabet":
+				syms := map[byte]bool{}
+				for i := 0; i < len(val); i++ {
+					c := val[i]
+					switch {
+					case c == ' ' || c == '\t' || c == ',':
+						continue
+					case c == '#' || c == '*' || c == '(' || c == ')' || c == '!' || c == ']':
+						return fail(valAt+i, "bad-alphabet", "%q cannot be an input symbol", val[i:i+1])
+					}
+					syms[c] = true
+				}
+				if len(syms) == 0 {
+					return fail(valAt, "bad-alphabet", "alphabet lists no symbols")
+				}
+				b := make([]byte, 0, len(syms))
+				for c := range syms {
+					b = append(b, c)
+				}
+				sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+				rs.Alphabet = string(b)
+			case "on-bounds":
+				switch val {
+				case "error":
+					rs.OnBounds = BoundsError
+				case "reject":
+					rs.OnBounds = BoundsReject
+				case "clamp":
+					rs.OnBounds = BoundsClamp
+				default:
+					return fail(valAt, "bad-on-bounds", "on-bounds must be error, reject or clamp, got %q", val)
+				}
+				if rs.Bounce && rs.OnBounds != BoundsReject {
+					return fail(0, "bounds-conflict", "endmarkers: bounce already rejects moves past the ends")
+				}
+			case "endmarkers":
+				switch val {
+				case "symbol":
+					rs.Bounce = false
+				case "bounce":
+					if rs.OnBounds != BoundsError && rs.OnBounds != BoundsReject {
+						return fail(valAt, "bounds-conflict", "endmarkers: bounce rejects moves past the ends, but on-bounds is %s", rs.OnBounds)
+					}
+					rs.Bounce, rs.OnBounds = true, BoundsReject
+				default:
+					return fail(valAt, "bad-endmarkers", "endmarkers must be symbol or bounce, got %q", val)
+				}
+			case "on-missing":
+				switch val {
+				case "error":
+					rs.OnMissing = MissingError
+				case "reject-sink":
+					rs.OnMissing = MissingRejectSink
+				case "ignore":
+					rs.OnMissing = MissingIgnore
+				default:
+					return fail(valAt, "bad-on-missing", "on-missing must be error, reject-sink or ignore, got %q", val)
+				}
+			default:
+				return fail(0, "unknown-directive", "unknown directive %q", strings.TrimSpace(key))
+			}
+			continue
+		}
+		// q] accept / reject
+		acc, rej := strings.Contains(line, "accept"), strings.Contains(line, "reject")
+		if i := strings.Index(line, "]"); i > 0 && (acc || rej) {
+			id, e := ParseStateID(line[:i])
+			if e != nil {
+				return fail(0, "bad-state", "%v", e)
+			}
+			if word := strings.TrimSpace(line[i+1:]); strict && word != "accept" && word != "reject" {
+				return fail(i+1, "stray-text", "expect a lone accept or reject, got %q", word)
+			}
+			lines = append(lines, Line{Line: ln, Col: lead + 1, ID: id, Accept: acc, Reject: !acc && rej})
+			if id > maxID {
+				maxID = id
+			}
+			continue
+		}
+
+		// q] left|right (x,y) (x,y) ...
+		parts := strings.SplitN(line, "]", 2)
+		if len(parts) != 2 {
+			return fail(0, "bad-syntax", "bad syntax")
+		}
+		id, e := ParseStateID(parts[0])
+		if e != nil {
+			return fail(0, "bad-state", "%v", e)
+		}
+		rest := strings.TrimSpace(parts[1])
+		restAt := len(line) - len(strings.TrimLeft(parts[1], " \t"))
+
+		lp := strings.IndexByte(rest, '(')
+		if lp < 0 {
+			return fail(restAt+len(rest), "bad-syntax", "missing '('")
+		}
+		dirStr := strings.TrimSpace(rest[:lp])
+		dir, ok := parseMoveLR(dirStr)
+		switch {
+		case rs.Kind == OneWay && dirStr == "":
+			dir = R
+		case rs.Kind == OneWay && dir != R:
+			return fail(restAt, "bad-direction", "dfa states only move right, got %q", dirStr)
+		case !ok:
+			return fail(restAt, "bad-direction", "TWA states move left or right, got %q", dirStr)
+		}
+
+		var pairs []Pair
+		right, at := rest[lp:], restAt+lp // at: offset of right in line
+		for {
+			l := strings.IndexByte(right, '(')
+			r := strings.IndexByte(right, ')')
+			if l < 0 || r < 0 || r < l {
+				if junk := strings.TrimSpace(right); strict && junk != "" {
+					return fail(at+strings.Index(right, junk), "stray-text", "unexpected %q after last pair", junk)
+				}
+				break
+			}
+			if junk := strings.TrimSpace(right[:l]); strict && junk != "" {
+				return fail(at+strings.Index(right, junk), "stray-text", "unexpected %q between pairs", junk)
+			}
+			open := at + l
+			inside := strings.TrimSpace(right[l+1 : r]) // "a,2"
+			right, at = right[r+1:], at+r+1
+			prio := 0
+			if strings.HasPrefix(right, "!") {
+				if version < 2 {
+					return fail(at, "needs-version", "priorities like (sym,to)!1 need version: 2")
+				}
+				n := 1
+				for n < len(right) && right[n] >= '0' && right[n] <= '9' {
+					n++
+				}
+				v, e := strconv.Atoi(right[1:n])
+				if e != nil || v < 1 {
+					return fail(at, "bad-priority", "priority must be a positive number after '!'")
+				}
+				prio, right, at = v, right[n:], at+n
+			}
+			xy := strings.Split(inside, ",")
+			if len(xy) != 2 && (version < 2 || len(xy) != 3) {
+				if version < 2 {
+					return fail(open, "bad-pair", "expect (sym,to)")
+				}
+				return fail(open, "bad-pair", "expect (sym,to) or (sym,to,dir)")
+			}
+			sym := strings.TrimSpace(xy[0])
+			to := strings.TrimSpace(xy[1])
+			eps := sym == "ε" || sym == "_" && rs.Nondet
+			switch {
+			case sym == "ε" && !rs.Nondet:
+				return fail(open, "needs-nondet", "epsilon pairs like (ε,to) need kind: nfa or 2nfa")
+			case eps && len(xy) == 3:
+				return fail(open, "bad-direction", "an epsilon pair does not move the head")
+			case eps:
+				sym = "ε"
+			case len(sym) != 1:
+				return fail(open, "bad-symbol", "bad symbol %q", sym)
+			}
+			v, e := ParseStateID(to)
+			if e != nil {
+				return fail(open, "bad-state", "bad to-state %q", to)
+			}
+			p := Pair{Sym: sym, To: v, Wild: version >= 2 && sym == "*", Eps: eps, Prio: prio, Col: lead + open + 1}
+			if len(xy) == 3 {
+				d, ok := parseMoveLR(xy[2])
+				if !ok {
+					return fail(open, "bad-direction", "move must be left/right, got %q", strings.TrimSpace(xy[2]))
+				}
+				if rs.Kind == OneWay && d != R {
+					return fail(open, "bad-direction", "dfa transitions only move right")
+				}
+				p.Dir = d
+			}
+			pairs = append(pairs, p)
+			if v > maxID {
+				maxID = v
+			}
+		}
+		lines = append(lines, Line{Line: ln, Col: lead + 1, ID: id, Dir: dir, Pairs: pairs})
+		if id > maxID {
+			maxID = id
+		}
+	}
+	if e := sc.Err(); e != nil {
+		return nil, e
+	}
+	if maxID == 0 {
+		return nil, Diagnostic{Severity: SevError, Code: "no-states", Message: "no states parsed"}
+	}
+	rs.Version, rs.Lines, rs.MaxID = version, lines, maxID
+	return rs, nil
+}
@@ -0,0 +1,229 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Runtime bounds a run, says how much of it the result remembers and
+// hooks into it between steps. The zero value of every field but MaxSteps
+// leaves its part out.
+type Runtime struct {
+	MaxSteps int
+	Trail    int // how many of the last steps the result keeps
+	// Monitor, if set, is a dfa fed every input symbol the run reads; the
+	// run fails as soon as it enters a reject state or has no transition.
+	// Only runs of deterministic machines have one sequence to feed it.
+	Monitor *Machine
+	// Search is the order a run of an nfa explores its choices in, and
+	// MaxDepth, if positive, cuts off branches longer than that many steps.
+	Search   SearchOrder
+	MaxDepth int
+	// Context, if set, ends the run between steps once it is done: timed
+	// out past its deadline, stopped if cancelled.
+	Context context.Context
+	// Visits, if set, counts how often the run enters each state, by id;
+	// the start state counts as entered once when the run begins.
+	Visits map[int]int
+	// OnStep, if set, is called with every step as it is taken.
+	OnStep func(ev StepEvent)
+	// Wait, if set, is called before each step after the first; an error
+	// stops the run with it as the reason.
+	Wait func() error
+}
+
+// Run runs m on tape, which must be wrapped in '#' endmarkers and pass
+// m.CheckTape. Runs of a deterministic machine always end: a repeated
+// configuration ends it as Looped. A run of an nfa searches its choices
+// and accepts if any branch does.
+func (cfg Runtime) Run(m *Machine, tape string) Result {
+	return cfg.RunTape(m, NewByteTape(tape))
+}
+
+// RunTape is Run on a tape from any backend. The head starts on position 1,
+// the first cell after the left endmarker.
+func (cfg Runtime) RunTape(m *Machine, t Tape) (res Result) {
+	if m.Nondet {
+		return cfg.search(m, t)
+	}
+
+	var (
+		q, i, step = m.Start, 1, 1
+		mq         *State // monitor state
+		dg         = newDigest()
+		// A deterministic machine that reaches the same (state, head)
+		// twice repeats itself forever; seen maps each one to its step.
+		seen = map[[2]int]int{}
+	)
+	cells := newRunCells(t)
+	res = Result{Schema: SchemaVersion, Tape: cells.String()}
+	defer func() { res.Digest = dg.sum(res.Outcome) }()
+
+	if cfg.Monitor != nil {
+		mq = cfg.Monitor.Start
+	}
+	if cfg.Visits != nil {
+		cfg.Visits[q.ID]++
+	}
+	ended := func() bool {
+		if cfg.Context == nil || cfg.Context.Err() == nil {
+			return false
+		}
+		res.Outcome, res.Reason = Stopped, "cancelled"
+		if cfg.Context.Err() == context.DeadlineExceeded {
+			res.Outcome, res.Reason = TimedOut, "time limit reached"
+		}
+		res.Reason += fmt.Sprintf(" after %d steps (state %d, head %d)", res.Steps, q.ID, i)
+		return true
+	}
+
+	for {
+		lo, hi := t.Bounds()
+		if i < lo || i >= hi {
+			res.Outcome, res.Reason = OutOfBounds, fmt.Sprintf("state %d%s moved the head off the tape to %d", q.ID, q.at(), i)
+			if m.OnBounds == BoundsReject {
+				res.Outcome, res.Reason = Rejected, res.Reason+" (on-bounds: reject)"
+				if m.Bounce {
+					res.Reason = fmt.Sprintf("state %d%s moved the head past an endmarker (endmarkers: bounce)", q.ID, q.at())
+				}
+			}
+			return res
+		}
+		if m.Kind == OneWay && i == hi-1 {
+			// A one-way machine decides when the head reaches the right
+			// endmarker, by the state it is in.
+			if q.Final {
+				res.Outcome, res.Reason = Accepted, fmt.Sprintf("input ended in accept state %d", q.ID)
+				res.Accepted = true
+			} else {
+				res.Outcome, res.Reason = Rejected, fmt.Sprintf("input ended in state %d%s, which is not an accept state", q.ID, q.at())
+			}
+			return res
+		}
+		if step > cfg.MaxSteps {
+			res.Outcome, res.Reason = StepLimit, fmt.Sprintf("no verdict after %d steps (state %d, head %d)", cfg.MaxSteps, q.ID, i)
+			return res
+		}
+		if step&1023 == 0 && ended() {
+			return res
+		}
+		cfgKey := [2]int{q.ID, i}
+		if first, ok := seen[cfgKey]; ok {
+			res.Outcome, res.Reason = Looped, fmt.Sprintf("state %d%s at head %d repeats step %d; the run never halts (%d configurations visited)", q.ID, q.at(), i, first, len(seen))
+			return res
+		}
+		seen[cfgKey] = step
+		res.Configs = len(seen)
+
+		sym := t.Read(i)
+		nxt, j, st, err := q.Step(t, i)
+		if err != nil {
+			res.Outcome, res.Reason = Stuck, fmt.Sprintf("state %d%s has no transition on %q at head %d", q.ID, q.at(), sym, i)
+			return res
+		}
+
+		e, _ := q.EdgeOn(sym)
+		mv := nxt.Dir
+		if j != i {
+			mv = Move(j - i)
+		}
+		if m.OnBounds == BoundsClamp {
+			j = min(max(j, lo), hi-1)
+		}
+		ev := StepEvent{
+			Step:    step,
+			State:   q.ID,
+			Dir:     q.Dir,
+			Read:    string(sym),
+			Next:    nxt.ID,
+			Move:    mv,
+			Head:    i,
+			NewHead: j,
+			Status:  st,
+			Line:    e.Line,
+			Cells:   cells.String(),
+		}
+		if cfg.OnStep != nil {
+			cfg.OnStep(ev)
+		}
+		dg.step(ev)
+		res.Steps = step
+		if cfg.Visits != nil {
+			cfg.Visits[nxt.ID]++
+		}
+		if cfg.Trail > 0 {
+			if len(res.Last) == cfg.Trail {
+				res.Last = append(res.Last[:0], res.Last[1:]...)
+			}
+			res.Last = append(res.Last, ev)
+		}
+		if mq != nil && sym != '#' {
+			e, err := mq.EdgeOn(sym)
+			if err != nil || e.To.Reject {
+				res.Outcome = Violated
+				res.Reason = fmt.Sprintf("monitor state %d%s has no transition on %q at head %d", mq.ID, mq.at(), sym, i)
+				if err == nil {
+					res.Reason = fmt.Sprintf("monitor entered reject state %d on %q at head %d", e.To.ID, sym, i)
+				}
+				return res
+			}
+			mq = e.To
+		}
+
+		switch st {
+		case Accept:
+			res.Outcome, res.Reason = Accepted, fmt.Sprintf("entered accept state %d at head %d", nxt.ID, i)
+			res.Accepted = true
+			return res
+		case Reject:
+			res.Outcome, res.Reason = Rejected, fmt.Sprintf("entered reject state %d from state %d%s on %q at head %d", nxt.ID, q.ID, q.at(), sym, i)
+			if nxt.Implicit {
+				res.Reason = fmt.Sprintf("state %d%s has no transition on %q at head %d (on-missing: reject-sink)", q.ID, q.at(), sym, i)
+			}
+			return res
+		default:
+			q, i = nxt, j
+			step++
+		}
+		if cfg.Wait == nil {
+			continue
+		}
+		if err := cfg.Wait(); err != nil {
+			if !ended() {
+				res.Outcome, res.Reason = Stopped, err.Error()
+			}
+			return res
+		}
+	}
+}
+
+// ParseTape checks that arg, trimmed, is a tape wrapped in '#' endmarkers
+// and returns it.
+func ParseTape(arg string) (string, error) {
+	s := strings.TrimSpace(arg)
+
+	if len(s) < 2 || s[0] != '#' || s[len(s)-1] != '#' {
+		return "", fmt.Errorf("tape must be wrapped with #...#")
+	}
+
+	return s, nil
+}
+
+// HighlightIndex shows tape with the cell under head in brackets.
+func HighlightIndex(tape string, head int) string {
+	if head < 0 || head >= len(tape) {
+		// 越界时就原样返回；按需你也可以在这里加提示
+		return tape
+	}
+	var b strings.Builder
+	b.Grow(len(tape) + 2)
+	b.WriteString(tape[:head])
+	b.WriteByte('[')
+	b.WriteByte(tape[head])
+	b.WriteByte(']')
+	if head+1 < len(tape) {
+		b.WriteString(tape[head+1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,167 @@
+package machine
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// SchemaVersion is the version of the JSON form of Result, StepEvent and
+// Diagnostic, described by result.schema.json. It changes when a field is
+// removed or changes meaning; new fields may appear without a bump.
+const SchemaVersion = 1
+
+// Result is the outcome of a run with the reason for it and the last
+// steps that led there.
+type Result struct {
+	Schema   int         `json:"schema"`
+	Tape     string      `json:"tape"`
+	Outcome  Outcome     `json:"outcome"`
+	Accepted bool        `json:"accepted"`
+	Steps    int         `json:"steps"`
+	Configs  int         `json:"configs"` // distinct configurations visited
+	Reason   string      `json:"reason"`
+	Last     []StepEvent `json:"last,omitempty"`
+	// Path is, for an nfa, the steps of the branch that accepted.
+	Path []StepEvent `json:"path,omitempty"`
+	// Digest identifies the run's behavior: equal digests mean the same
+	// steps in the same order and the same outcome.
+	Digest string `json:"digest"`
+}
+
+// digest hashes the steps of a run canonically: for every step the state,
+// the symbol read, the next state, the move and the head delta, then the
+// outcome. It leaves out everything else (timing, the trail, the reason's
+// wording), so the digest of a run changes only when its behavior does.
+type digest struct {
+	h   hash.Hash
+	buf []byte
+}
+
+func newDigest() *digest { return &digest{h: sha256.New()} }
+
+func (d *digest) step(ev StepEvent) {
+	b := binary.AppendVarint(d.buf[:0], int64(ev.State))
+	b = append(b, ev.Read[0])
+	b = binary.AppendVarint(b, int64(ev.Next))
+	b = binary.AppendVarint(b, int64(ev.Move))
+	b = binary.AppendVarint(b, int64(ev.NewHead-ev.Head))
+	d.buf = b
+	d.h.Write(b)
+}
+
+// sum finishes the digest with the outcome, as 32 hex digits.
+func (d *digest) sum(o Outcome) string {
+	d.h.Write([]byte(o.String()))
+	return hex.EncodeToString(d.h.Sum(nil)[:16])
+}
+
+// StepEvent describes one step of a run; it is also what --trace-template
+// templates are executed against.
+type StepEvent struct {
+	Step    int        `json:"step"`
+	State   int        `json:"state"` // state the step was taken from
+	Dir     Move       `json:"dir"`   // that state's direction
+	Read    string     `json:"read"`
+	Next    int        `json:"next"`
+	Move    Move       `json:"move"`
+	Head    int        `json:"head"`    // head before the step
+	NewHead int        `json:"newHead"` // head after the step
+	Status  StepStatus `json:"status"`
+	Line    int        `json:"line,omitempty"` // rules-file line of the pair taken
+
+	// Cells is the tape as it was for the step; Tape shows it with the
+	// head marked. It stays out of JSON, which would repeat it each step.
+	Cells string `json:"-"`
+}
+
+// Tape renders the tape with the cell under the head bracketed.
+func (ev StepEvent) Tape() string {
+	return HighlightIndex(ev.Cells, ev.Head)
+}
+
+// Diagnostic is a problem found in a rules file. Line is 0 for problems
+// that belong to no single line, Column 0 when no column is known. Code is
+// a stable kebab-case name for the kind of problem, for tools to match on.
+// A Diagnostic is also the error ParseRules returns.
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", d.Line, d.Severity, d.Message)
+}
+
+func (d Diagnostic) Error() string {
+	if d.Line == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("line %d: %s", d.Line, d.Message)
+}
+
+// LintReport is what lint --json prints.
+type LintReport struct {
+	Schema      int          `json:"schema"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// The enums below travel as their String forms.
+
+func (o Outcome) MarshalText() ([]byte, error) { return []byte(o.String()), nil }
+
+func (o *Outcome) UnmarshalText(b []byte) error {
+	for v := Accepted; v <= TimedOut; v++ {
+		if v.String() == string(b) {
+			*o = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown outcome %q", b)
+}
+
+func (m Move) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+
+func (m *Move) UnmarshalText(b []byte) error {
+	v, ok := parseMoveLR(string(b))
+	if !ok {
+		return fmt.Errorf("unknown move %q", b)
+	}
+	*m = v
+	return nil
+}
+
+func (st StepStatus) MarshalText() ([]byte, error) { return []byte(st.String()), nil }
+
+func (st *StepStatus) UnmarshalText(b []byte) error {
+	for v := Continue; v <= Reject; v++ {
+		if v.String() == string(b) {
+			*st = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown step status %q", b)
+}
+
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *Severity) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "error":
+		*s = SevError
+	case "warning":
+		*s = SevWarning
+	default:
+		return fmt.Errorf("unknown severity %q", b)
+	}
+	return nil
+}
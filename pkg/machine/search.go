@@ -0,0 +1,214 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+)
+
+// SearchOrder is the order a run of an nfa explores its choices in.
+type SearchOrder int
+
+const (
+	BreadthFirst SearchOrder = iota // shortest branches first: an accepting path found is a shortest one
+	DepthFirst                      // each branch as far as it goes, first choice first
+)
+
+func (o SearchOrder) String() string {
+	if o == DepthFirst {
+		return "dfs"
+	}
+	return "bfs"
+}
+
+// ParseSearchOrder reads "bfs" or "dfs".
+func ParseSearchOrder(s string) (SearchOrder, error) {
+	switch s {
+	case "bfs":
+		return BreadthFirst, nil
+	case "dfs":
+		return DepthFirst, nil
+	}
+	return 0, fmt.Errorf("search must be bfs or dfs, got %q", s)
+}
+
+// branch is a configuration the search reached, with the step that led
+// there from the branch it came from.
+type branch struct {
+	q     *State
+	i     int
+	depth int
+	from  int // index of the parent branch, -1 for the start
+	ev    StepEvent
+}
+
+// search runs an nfa on tape: every step from a configuration is taken,
+// epsilon moves included, and the run accepts as soon as one branch does.
+// Epsilon moves keep the head in place, so a cycle of them repeats a
+// configuration and is not followed around. A branch ends where a
+// deterministic run would (a reject state, no transition, off the tape);
+// a configuration explored before is not explored again, so the search
+// always ends. Steps counts the steps taken over all branches, and
+// MaxSteps bounds it.
+func (cfg Runtime) search(m *Machine, t Tape) (res Result) {
+	var (
+		branches = []branch{{q: m.Start, i: 1, from: -1}}
+		todo     = []int{0}
+		dg       = newDigest()
+		// shallowest depth each (state, head) was explored at: a depth-first
+		// search explores it again if it comes back shallower, so a depth
+		// limit cuts no path that a breadth-first search would keep
+		seen = map[[2]int]int{}
+		cut  = 0 // branches cut off by MaxDepth
+	)
+	cells := newRunCells(t)
+	res = Result{Schema: SchemaVersion, Tape: cells.String()}
+	defer func() { res.Digest = dg.sum(res.Outcome) }()
+
+	if cfg.Visits != nil {
+		cfg.Visits[m.Start.ID]++
+	}
+	accept := func(k int, reason string) Result {
+		for ; branches[k].from >= 0; k = branches[k].from {
+			res.Path = append(res.Path, branches[k].ev)
+		}
+		for l, r := 0, len(res.Path)-1; l < r; l, r = l+1, r-1 {
+			res.Path[l], res.Path[r] = res.Path[r], res.Path[l]
+		}
+		for n := range res.Path {
+			res.Path[n].Step = n + 1
+		}
+		res.Outcome, res.Reason, res.Accepted = Accepted, reason, true
+		return res
+	}
+	ended := func() bool {
+		if cfg.Context == nil || cfg.Context.Err() == nil {
+			return false
+		}
+		res.Outcome, res.Reason = Stopped, "cancelled"
+		if cfg.Context.Err() == context.DeadlineExceeded {
+			res.Outcome, res.Reason = TimedOut, "time limit reached"
+		}
+		res.Reason += fmt.Sprintf(" after %d steps (%d configurations explored)", res.Steps, len(seen))
+		return true
+	}
+
+	// Steps can jump by several per branch taken, so the context is
+	// checked by a count of loop turns instead
+	for turn := 1; len(todo) > 0; turn++ {
+		if turn&1023 == 0 && ended() {
+			return res
+		}
+		var k int
+		if cfg.Search == DepthFirst {
+			k, todo = todo[len(todo)-1], todo[:len(todo)-1]
+		} else {
+			k, todo = todo[0], todo[1:]
+		}
+		b := branches[k]
+		// a one-way branch at the right endmarker has read its input: it
+		// accepts in a final state, and can only go on by epsilon moves
+		lo, hi := t.Bounds()
+		atEnd := m.Kind == OneWay && b.i == hi-1
+		if atEnd && b.q.Final {
+			return accept(k, fmt.Sprintf("a branch ended the input in accept state %d", b.q.ID))
+		}
+		key := [2]int{b.q.ID, b.i}
+		if d, ok := seen[key]; ok && d <= b.depth {
+			continue
+		}
+		seen[key] = b.depth
+		res.Configs = len(seen)
+		if cfg.MaxDepth > 0 && b.depth >= cfg.MaxDepth {
+			cut++
+			continue
+		}
+
+		// epsilon moves first, then the moves on the symbol under the head
+		sym := t.Read(b.i)
+		edges := b.q.Eps
+		if !atEnd {
+			edges = append(edges[:len(edges):len(edges)], b.q.EdgesOn(sym)...)
+		}
+		var next []int
+		for n, e := range edges {
+			eps := n < len(b.q.Eps)
+			if res.Steps >= cfg.MaxSteps {
+				res.Outcome, res.Reason = StepLimit, fmt.Sprintf("no branch accepted after %d steps (%d configurations explored)", cfg.MaxSteps, len(seen))
+				return res
+			}
+			if res.Steps > 0 && cfg.Wait != nil {
+				if err := cfg.Wait(); err != nil {
+					if !ended() {
+						res.Outcome, res.Reason = Stopped, err.Error()
+					}
+					return res
+				}
+			}
+			nxt, j, st := e.To, t.Move(b.i, e.Move()), Continue
+			read := string(sym)
+			if eps {
+				j, read = b.i, "ε"
+			}
+			switch {
+			case nxt.Accept:
+				j, st = b.i, Accept
+			case nxt.Reject:
+				j, st = b.i, Reject
+			case m.OnBounds == BoundsClamp:
+				j = min(max(j, lo), hi-1)
+			}
+			mv := nxt.Dir
+			if j != b.i {
+				mv = Move(j - b.i)
+			}
+			res.Steps++
+			ev := StepEvent{
+				Step:    res.Steps,
+				State:   b.q.ID,
+				Dir:     b.q.Dir,
+				Read:    read,
+				Next:    nxt.ID,
+				Move:    mv,
+				Head:    b.i,
+				NewHead: j,
+				Status:  st,
+				Line:    e.Line,
+				Cells:   cells.String(),
+			}
+			if cfg.OnStep != nil {
+				cfg.OnStep(ev)
+			}
+			dg.step(ev)
+			if cfg.Visits != nil {
+				cfg.Visits[nxt.ID]++
+			}
+			if cfg.Trail > 0 {
+				if len(res.Last) == cfg.Trail {
+					res.Last = append(res.Last[:0], res.Last[1:]...)
+				}
+				res.Last = append(res.Last, ev)
+			}
+			branches = append(branches, branch{q: nxt, i: j, depth: b.depth + 1, from: k, ev: ev})
+			if st == Accept {
+				return accept(len(branches)-1, fmt.Sprintf("a branch entered accept state %d at head %d", nxt.ID, b.i))
+			}
+			if lo, hi := t.Bounds(); st == Continue && j >= lo && j < hi {
+				next = append(next, len(branches)-1)
+			}
+		}
+		if cfg.Search == DepthFirst {
+			// the first choice on top, to be explored first
+			for l, r := 0, len(next)-1; l < r; l, r = l+1, r-1 {
+				next[l], next[r] = next[r], next[l]
+			}
+		}
+		todo = append(todo, next...)
+	}
+
+	if cut > 0 {
+		res.Outcome, res.Reason = StepLimit, fmt.Sprintf("no branch accepted within %d steps; %d branches were cut off there (%d configurations explored)", cfg.MaxDepth, cut, len(seen))
+		return res
+	}
+	res.Outcome, res.Reason = Rejected, fmt.Sprintf("no branch accepts (%d configurations explored)", len(seen))
+	return res
+}
@@ -0,0 +1,66 @@
+package machine
+
+import "fmt"
+
+// Snapshot is a configuration of a run after Steps steps: the state it is
+// in, the head and the tape.
+type Snapshot struct {
+	Steps int    `json:"steps"`
+	State int    `json:"state"`
+	Head  int    `json:"head"`
+	Tape  string `json:"tape"`
+}
+
+// String shows the snapshot as "state 4, head 3: #aa[b]#".
+func (s Snapshot) String() string {
+	return fmt.Sprintf("state %d, head %d: %s", s.State, s.Head, HighlightIndex(s.Tape, s.Head))
+}
+
+// Snapshot runs m on tape for exactly n steps and returns the
+// configuration reached, so a test can check a run partway and not only
+// its verdict:
+//
+//	snap, err := machine.Runtime{}.Snapshot(m, "#aab#", 5)
+//	// snap.State, snap.Head
+//
+// A run that loops is followed around its loop, so every n is reached; a
+// run that ends sooner is an error, and so is an nfa, whose run has no
+// single configuration. MaxSteps is ignored; OnStep and the other hooks
+// see the steps taken.
+func (cfg Runtime) Snapshot(m *Machine, tape string, n int) (Snapshot, error) {
+	if m.Nondet {
+		return Snapshot{}, fmt.Errorf("a run of an %s branches: it has no single configuration after %d steps", m.KindName(), n)
+	}
+	if n < 0 {
+		return Snapshot{}, fmt.Errorf("cannot snapshot after %d steps", n)
+	}
+	// snaps[k] is the configuration after k steps
+	snaps := []Snapshot{{State: m.Start.ID, Head: 1, Tape: tape}}
+	onStep := cfg.OnStep
+	cfg.OnStep = func(ev StepEvent) {
+		if onStep != nil {
+			onStep(ev)
+		}
+		snaps = append(snaps, Snapshot{Steps: ev.Step, State: ev.Next, Head: ev.NewHead, Tape: ev.Cells})
+	}
+	cfg.MaxSteps = n
+	res := cfg.Run(m, tape)
+
+	last := len(snaps) - 1
+	if last == n {
+		return snaps[n], nil
+	}
+	if res.Outcome == Looped {
+		// the last configuration repeats an earlier one, and the run goes
+		// round the steps between them forever
+		cur := snaps[last]
+		for k, s := range snaps[:last] {
+			if s.State == cur.State && s.Head == cur.Head {
+				snap := snaps[k+(n-k)%(last-k)]
+				snap.Steps = n
+				return snap, nil
+			}
+		}
+	}
+	return Snapshot{}, fmt.Errorf("the run ended after %d steps, before step %d: %s", last, n, res.Reason)
+}
@@ -0,0 +1,84 @@
+package machine
+
+import "strings"
+
+// Tape is the storage a run reads its cells from. Bounds says which
+// positions hold cells, lo through hi-1, and a move past them leaves the
+// tape. Move gives the position one cell from i towards d, so a backend
+// that grows can add cells as the head comes near an end; Write changes a
+// cell, for backends that are edited between runs.
+type Tape interface {
+	Read(i int) byte
+	Write(i int, sym byte)
+	Move(i int, d Move) int
+	Bounds() (lo, hi int)
+}
+
+// ByteTape is a tape held in memory as a byte slice, fixed in size: the
+// tape of every run given as a string.
+type ByteTape struct {
+	cells []byte
+	str   string // cells as a string, "" after a Write
+}
+
+// NewByteTape makes a tape of the cells of s, which must be wrapped in
+// '#' endmarkers like any tape.
+func NewByteTape(s string) *ByteTape {
+	return &ByteTape{cells: []byte(s), str: s}
+}
+
+func (t *ByteTape) Read(i int) byte { return t.cells[i] }
+
+func (t *ByteTape) Write(i int, sym byte) {
+	t.cells[i] = sym
+	t.str = ""
+}
+
+func (t *ByteTape) Move(i int, d Move) int { return i + int(d) }
+
+func (t *ByteTape) Bounds() (lo, hi int) { return 0, len(t.cells) }
+
+func (t *ByteTape) String() string {
+	if t.str == "" {
+		t.str = string(t.cells)
+	}
+	return t.str
+}
+
+// runCells is TapeString for the steps of one run, built once: a run
+// never writes, so the cells change only when a growing backend adds some,
+// which moves its bounds. Backends without a cheap String would otherwise
+// cost a pass over the tape every step.
+type runCells struct {
+	t      Tape
+	lo, hi int
+	s      string
+}
+
+func newRunCells(t Tape) *runCells {
+	c := &runCells{t: t}
+	c.lo, c.hi = t.Bounds()
+	c.s = TapeString(t)
+	return c
+}
+
+func (c *runCells) String() string {
+	if lo, hi := c.t.Bounds(); lo != c.lo || hi != c.hi {
+		c.lo, c.hi, c.s = lo, hi, TapeString(c.t)
+	}
+	return c.s
+}
+
+// TapeString is the cells of t as a string, for step events and results.
+func TapeString(t Tape) string {
+	if s, ok := t.(interface{ String() string }); ok {
+		return s.String()
+	}
+	lo, hi := t.Bounds()
+	var b strings.Builder
+	b.Grow(hi - lo)
+	for i := lo; i < hi; i++ {
+		b.WriteByte(t.Read(i))
+	}
+	return b.String()
+}
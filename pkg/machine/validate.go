@@ -0,0 +1,198 @@
+package machine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SevError Severity = iota
+	SevWarning
+)
+
+func (s Severity) String() string {
+	if s == SevWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Validate checks parsed rules against TWA semantics before the graph is
+// built: every state reached must be defined, a state keeps one direction
+// and one target per symbol, and halting states carry no transitions (in a
+// dfa, accept states are not halting and keep theirs). In an nfa a
+// symbol may have several targets, all of them taken; in a version 1 file
+// a second target is only warned about, the last one winning.
+// Transitions on symbols outside a declared alphabet are warned about.
+// Strict mode also rejects those repeats, and states that nothing ever
+// goes to.
+func Validate(rs *Rules, strict bool) []Diagnostic {
+
+	var diags []Diagnostic
+	add := func(sev Severity, ln, col int, code, format string, args ...any) {
+		diags = append(diags, Diagnostic{Line: ln, Column: col, Severity: sev, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	type info struct {
+		ln, col  int // first line defining the state
+		dirLn    int // first line giving the state a direction
+		dirCol   int
+		dir      Move
+		acc, rej bool
+		syms     map[string][]pairAt // symbol -> its transitions
+		order    []string            // the keys of syms, in file order
+	}
+	defs := map[int]*info{}
+	for _, ln := range rs.Lines {
+		d := defs[ln.ID]
+		if d == nil {
+			d = &info{ln: ln.Line, col: ln.Col, syms: map[string][]pairAt{}}
+			defs[ln.ID] = d
+		}
+		if ln.Accept {
+			d.acc = true
+		}
+		if ln.Reject {
+			d.rej = true
+		}
+		if len(ln.Pairs) > 0 {
+			if d.dirLn == 0 {
+				d.dir, d.dirLn, d.dirCol = ln.Dir, ln.Line, ln.Col
+			} else if d.dir != ln.Dir {
+				add(SevError, ln.Line, ln.Col, "direction-conflict", "state %d moves %s here but %s on line %d", ln.ID, ln.Dir, d.dir, d.dirLn)
+			}
+		}
+		for _, p := range ln.Pairs {
+			if rs.Nondet && p.Prio != 0 {
+				add(SevError, ln.Line, p.Col, "nondet-priority", "state %d: an nfa takes every transition on a symbol, so (%s,%d)!%d needs no priority", ln.ID, p.Sym, p.To, p.Prio)
+				continue
+			}
+			if prev := d.syms[p.Sym]; rs.Nondet && len(prev) > 0 {
+				if same := samePair(prev, p); same != nil {
+					add(SevWarning, ln.Line, p.Col, "duplicate-transition", "state %d repeats (%s,%d) from line %d", ln.ID, p.Sym, p.To, same.ln)
+				}
+			} else if len(prev) > 0 {
+				first := prev[0]
+				switch {
+				case p.Prio != 0 && first.Prio != 0:
+					if clash := samePrio(prev, p.Prio); clash != nil {
+						add(SevError, ln.Line, p.Col, "priority-clash", "state %d has two transitions on %q with priority %d (other on line %d)", ln.ID, p.Sym, p.Prio, clash.ln)
+						continue
+					}
+				case rs.Version < 2 && !strict:
+					// version 1 files have always been read last-one-wins
+					add(SevWarning, ln.Line, p.Col, "duplicate-transition", "state %d has a second transition on %q (first on line %d); the last one wins", ln.ID, p.Sym, first.ln)
+				default:
+					add(SevError, ln.Line, p.Col, "duplicate-transition", "state %d has a second transition on %q (first on line %d)", ln.ID, p.Sym, first.ln)
+					continue
+				}
+			}
+			if len(d.syms[p.Sym]) == 0 {
+				d.order = append(d.order, p.Sym)
+			}
+			d.syms[p.Sym] = append(d.syms[p.Sym], pairAt{ln: ln.Line, Pair: p})
+		}
+	}
+
+	ids := make([]int, 0, len(defs))
+	for id := range defs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		d := defs[id]
+		if d.acc && d.rej {
+			add(SevError, d.ln, d.col, "accept-and-reject", "state %d is marked both accept and reject", id)
+		}
+		if (d.acc && rs.Kind == TwoWay || d.rej) && len(d.syms) > 0 {
+			add(SevWarning, d.dirLn, d.dirCol, "dead-transitions", "state %d halts on entry; its transitions never fire", id)
+		}
+		for _, sym := range d.order {
+			if rs.Nondet {
+				break // every choice is taken, so none is shadowed
+			}
+			// as BuildGraph keeps it: the lowest priority, the last of equals
+			ps := d.syms[sym]
+			best := ps[0]
+			for _, p := range ps {
+				if p.Prio <= best.Prio {
+					best = p
+				}
+			}
+			for _, p := range ps {
+				// pairs without priorities were reported as duplicates
+				if p != best && p.Prio != 0 {
+					add(SevWarning, p.ln, p.Col, "shadowed", "state %d: (%s,%d)!%d is shadowed by (%s,%d)!%d on line %d", id, sym, p.To, p.Prio, sym, best.To, best.Prio, best.ln)
+				}
+			}
+		}
+	}
+
+	referenced := map[int]bool{1: true}
+	for _, ln := range rs.Lines {
+		for _, p := range ln.Pairs {
+			referenced[p.To] = true
+			if defs[p.To] == nil {
+				add(SevError, ln.Line, p.Col, "undefined-state", "state %d goes to undefined state %d on %q", ln.ID, p.To, p.Sym)
+			}
+			if rs.Kind == OneWay && p.Sym == "#" {
+				add(SevWarning, ln.Line, p.Col, "dead-transitions", "state %d: a dfa decides at the right endmarker, so (#,%d) never fires", ln.ID, p.To)
+			}
+			if rs.Alphabet != "" && p.Sym != "#" && !p.Wild && !p.Eps && !strings.Contains(rs.Alphabet, p.Sym) {
+				add(SevWarning, ln.Line, p.Col, "outside-alphabet", "state %d: %q is not in the alphabet; the transition never fires", ln.ID, p.Sym)
+			}
+		}
+	}
+	if rs.Nondet && strings.Contains(rs.Alphabet, "_") {
+		add(SevError, 0, 0, "bad-alphabet", "in an nfa _ stands for the empty word, so it cannot be an input symbol")
+	}
+	inGroup := map[int]string{}
+	for _, g := range rs.Groups {
+		for k, id := range g.IDs {
+			if defs[id] == nil {
+				add(SevError, g.Line, g.Cols[k], "undefined-state", "group %s lists undefined state %d", g.Name, id)
+			} else if other, ok := inGroup[id]; ok {
+				add(SevError, g.Line, g.Cols[k], "group-overlap", "state %d is already in group %s", id, other)
+			} else {
+				inGroup[id] = g.Name
+			}
+		}
+	}
+	if strict {
+		for _, id := range ids {
+			if !referenced[id] {
+				add(SevError, defs[id].ln, defs[id].col, "unreferenced-state", "state %d is never the target of a transition", id)
+			}
+		}
+	}
+	if defs[1] == nil {
+		add(SevError, 0, 0, "no-start", "start state 1 is not defined")
+	}
+	return diags
+}
+
+type pairAt struct {
+	ln int
+	Pair
+}
+
+func samePair(ps []pairAt, p Pair) *pairAt {
+	for i := range ps {
+		if ps[i].To == p.To && ps[i].Dir == p.Dir {
+			return &ps[i]
+		}
+	}
+	return nil
+}
+
+func samePrio(ps []pairAt, prio int) *pairAt {
+	for i := range ps {
+		if ps[i].Prio == prio {
+			return &ps[i]
+		}
+	}
+	return nil
+}
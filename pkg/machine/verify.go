@@ -0,0 +1,58 @@
+package machine
+
+// ForEachWord calls fn on every word over alphabet of length 0 to maxLen,
+// shortest first and in alphabet order within a length, until fn returns
+// false. A negative maxLen has no words.
+func ForEachWord(alphabet string, maxLen int, fn func(w string) bool) {
+	if maxLen < 0 {
+		return
+	}
+	word := make([]byte, 0, maxLen)
+	idx := make([]int, 0, maxLen)
+	for n := 0; n <= maxLen; n++ {
+		word, idx = word[:n], idx[:n]
+		for k := range word {
+			word[k], idx[k] = alphabet[0], 0
+		}
+		for {
+			if !fn(string(word)) {
+				return
+			}
+			// odometer increment, rightmost position fastest
+			k := n - 1
+			for ; k >= 0; k-- {
+				if idx[k]++; idx[k] < len(alphabet) {
+					word[k] = alphabet[idx[k]]
+					break
+				}
+				idx[k], word[k] = 0, alphabet[0]
+			}
+			if k < 0 {
+				break
+			}
+		}
+		if len(alphabet) == 0 {
+			return
+		}
+	}
+}
+
+// VerifyAgainst runs the machine on every word over alphabet (the
+// machine's own if empty) of length 0 to maxLen and compares the verdict
+// with oracle's, which gets the word without endmarkers. It returns
+// whether they always agree, and the words where they do not, shortest
+// first; a negative maxLen checks nothing. Each word runs as m.Run runs
+// it, so WithMaxSteps, WithContext and the other options set its limits;
+// runs that end without a verdict count as not accepted.
+func VerifyAgainst(m *Machine, oracle func(string) bool, alphabet string, maxLen int) (ok bool, failures []string) {
+	if alphabet == "" {
+		alphabet, _ = m.Alphabet()
+	}
+	ForEachWord(alphabet, maxLen, func(w string) bool {
+		if m.Run("#"+w+"#").Accepted != oracle(w) {
+			failures = append(failures, w)
+		}
+		return true
+	})
+	return len(failures) == 0, failures
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// pumpSplit splits w as x y z at the first state the DFA repeats while
+// reading it, so |xy| is at most the number of states and y is not empty.
+// ok is false when no state repeats, which needs w shorter than that.
+func pumpSplit(delta [][]int, alphabet string, w string) (x, y, z string, ok bool) {
+	at := map[int]int{0: 0} // state -> position it was first in at
+	q := 0
+	for k := 0; k < len(w); k++ {
+		q = delta[q][strings.IndexByte(alphabet, w[k])]
+		if i, seen := at[q]; seen {
+			return w[:i], w[i : k+1], w[k+1:], true
+		}
+		at[q] = k + 1
+	}
+	return "", "", "", false
+}
+
+// pumpCmd demonstrates the pumping lemma on an accepted input: it splits
+// it as x y z and runs the machine on x y^i z for i = 0..--max-i.
+func pumpCmd(args []string) {
+	fs := flag.NewFlagSet("pump", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	maxI := fs.Int("max-i", 3, "pump y up to this many times")
+	maxSteps := fs.Int("max-steps", 1000000, "give up on a run after this many steps")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 2 || *maxI < 0 {
+		fmt.Println("Usage: go run . pump [flags] <rules.txt> <#tape#>")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := loadDeterministic(args[0], *strict, os.Stderr)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tape, err := machine.ParseTape(args[1])
+	if err == nil {
+		err = m.CheckTape(tape)
+	}
+	if err != nil {
+		fmt.Println("tape error:", err)
+		return
+	}
+	if strings.Contains(tape[1:len(tape)-1], "#") {
+		// the one-way DFA reads input symbols only; it has no column for '#'
+		fmt.Println("tape error: '#' inside the input cannot be pumped: the one-way DFA reads only the input symbols")
+		return
+	}
+	alphabet, delta, _, ok := languageOf(m)
+	if !ok {
+		return
+	}
+
+	cfg := machine.Runtime{MaxSteps: *maxSteps}
+	if res := runSilent(tape, m, cfg); !res.Accepted {
+		fmt.Printf("%s is not accepted (%s): only accepted inputs can be pumped\n", tape, res.Outcome)
+		return
+	}
+	n := len(delta)
+	w := tape[1 : len(tape)-1]
+	fmt.Printf("pumping length n = %d (states of the one-way DFA)\n", n)
+	x, y, z, ok := pumpSplit(delta, alphabet, w)
+	if !ok {
+		fmt.Printf("%s visits no state twice: it is shorter than n, so the lemma says nothing about it\n", tape)
+		return
+	}
+	fmt.Printf("x = %q, y = %q, z = %q  (|xy| = %d <= n, |y| = %d >= 1)\n", x, y, z, len(x)+len(y), len(y))
+
+	failed := false
+	for i := 0; i <= *maxI; i++ {
+		t := "#" + x + strings.Repeat(y, i) + z + "#"
+		res := runSilent(t, m, cfg)
+		fmt.Printf("  i = %d: %s  %s\n", i, t, res.Outcome)
+		failed = failed || !res.Accepted
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
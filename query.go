@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// stepFields are the fields a query can test, by name: numbers compare
+// with all six operators, the others only with == and !=.
+var stepFields = map[string]func(ev machine.StepEvent) (num int, text string){
+	"step":    func(ev machine.StepEvent) (int, string) { return ev.Step, "" },
+	"state":   func(ev machine.StepEvent) (int, string) { return ev.State, "" },
+	"next":    func(ev machine.StepEvent) (int, string) { return ev.Next, "" },
+	"head":    func(ev machine.StepEvent) (int, string) { return ev.Head, "" },
+	"newhead": func(ev machine.StepEvent) (int, string) { return ev.NewHead, "" },
+	"line":    func(ev machine.StepEvent) (int, string) { return ev.Line, "" },
+	"read":    func(ev machine.StepEvent) (int, string) { return 0, ev.Read },
+	"move":    func(ev machine.StepEvent) (int, string) { return 0, ev.Move.String() },
+	"dir":     func(ev machine.StepEvent) (int, string) { return 0, ev.Dir.String() },
+	"status":  func(ev machine.StepEvent) (int, string) { return 0, ev.Status.String() },
+}
+
+var textFields = map[string]bool{"read": true, "move": true, "dir": true, "status": true}
+
+// stepQuery is a parsed query: what to report (first, last, count or
+// steps, which lists them) about the steps that match.
+type stepQuery struct {
+	verb  string
+	match func(ev machine.StepEvent) bool
+}
+
+// parseQuery reads a query:
+//
+//	first step [where cond]
+//	last step [where cond]
+//	count steps [where cond]
+//	steps [where cond]
+//
+// where cond is comparisons like state==7 or read!=a joined with and, or,
+// not (also &&, || and !) and parentheses. Symbols may be quoted:
+// read=="#".
+func parseQuery(src string) (*stepQuery, error) {
+	toks, err := queryTokens(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	q := &stepQuery{verb: p.next(), match: func(machine.StepEvent) bool { return true }}
+	switch q.verb {
+	case "first", "last", "count":
+		if w := p.next(); w != "step" && w != "steps" {
+			return nil, fmt.Errorf("expect step after %s, got %q", q.verb, w)
+		}
+	case "steps", "step":
+		q.verb = "steps"
+	default:
+		return nil, fmt.Errorf("a query starts with first, last, count or steps, got %q", q.verb)
+	}
+	if p.peek() == "" {
+		return q, nil
+	}
+	if w := p.next(); w != "where" {
+		return nil, fmt.Errorf("expect where, got %q", w)
+	}
+	if q.match, err = p.or(); err != nil {
+		return nil, err
+	}
+	if w := p.peek(); w != "" {
+		return nil, fmt.Errorf("unexpected %q", w)
+	}
+	return q, nil
+}
+
+// queryTokens splits a query into words, operators and parentheses.
+func queryTokens(src string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, src[i:i+1])
+			i++
+		case strings.HasPrefix(src[i:], "&&"):
+			toks = append(toks, "and")
+			i += 2
+		case strings.HasPrefix(src[i:], "||"):
+			toks = append(toks, "or")
+			i += 2
+		case c == '&' || c == '|':
+			return nil, fmt.Errorf("%c alone is not an operator; use %c%c", c, c, c)
+		case strings.IndexByte("=!<>", c) >= 0:
+			n := 1
+			if i+1 < len(src) && src[i+1] == '=' {
+				n = 2
+			}
+			toks = append(toks, src[i:i+n])
+			i += n
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed %c", c)
+			}
+			toks = append(toks, src[i:i+end+2])
+			i += end + 2
+		default:
+			j := i
+			for j < len(src) && strings.IndexByte(" \t()=!<>&|", src[j]) < 0 {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []string
+	at   int
+}
+
+func (p *queryParser) peek() string {
+	if p.at < len(p.toks) {
+		return p.toks[p.at]
+	}
+	return ""
+}
+
+func (p *queryParser) next() string {
+	t := p.peek()
+	p.at++
+	return t
+}
+
+func (p *queryParser) or() (func(machine.StepEvent) bool, error) {
+	l, err := p.and()
+	for err == nil && p.peek() == "or" {
+		p.next()
+		var r func(machine.StepEvent) bool
+		if r, err = p.and(); err == nil {
+			l0 := l
+			l = func(ev machine.StepEvent) bool { return l0(ev) || r(ev) }
+		}
+	}
+	return l, err
+}
+
+func (p *queryParser) and() (func(machine.StepEvent) bool, error) {
+	l, err := p.unary()
+	for err == nil && p.peek() == "and" {
+		p.next()
+		var r func(machine.StepEvent) bool
+		if r, err = p.unary(); err == nil {
+			l0 := l
+			l = func(ev machine.StepEvent) bool { return l0(ev) && r(ev) }
+		}
+	}
+	return l, err
+}
+
+func (p *queryParser) unary() (func(machine.StepEvent) bool, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(ev machine.StepEvent) bool { return !f(ev) }, nil
+	case "(":
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if w := p.next(); w != ")" {
+			return nil, fmt.Errorf("expect ), got %q", w)
+		}
+		return f, nil
+	}
+	return p.compare()
+}
+
+// parseCond reads a whole condition, as after where.
+func parseCond(src string) (func(machine.StepEvent) bool, error) {
+	toks, err := queryTokens(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if w := p.peek(); w != "" {
+		return nil, fmt.Errorf("unexpected %q", w)
+	}
+	return f, nil
+}
+
+// compare reads field op value.
+func (p *queryParser) compare() (func(machine.StepEvent) bool, error) {
+	name := strings.ToLower(p.next())
+	field, ok := stepFields[name]
+	if !ok {
+		names := make([]string, 0, len(stepFields))
+		for n := range stepFields {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown field %q (fields: %s)", name, strings.Join(names, ", "))
+	}
+	op := p.next()
+	if op == "=" {
+		op = "=="
+	}
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expect a comparison after %s, got %q", name, op)
+	}
+	val := p.next()
+	if val == "" {
+		return nil, fmt.Errorf("%s %s needs a value", name, op)
+	}
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') {
+		val = val[1 : len(val)-1]
+	}
+
+	if textFields[name] {
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("%s compares only with == and !=", name)
+		}
+		want := op == "=="
+		if name != "read" {
+			val = strings.ToLower(val)
+		}
+		return func(ev machine.StepEvent) bool {
+			_, got := field(ev)
+			if name != "read" {
+				got = strings.ToLower(got)
+			}
+			return (got == val) == want
+		}, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return nil, fmt.Errorf("%s compares with a number, got %q", name, val)
+	}
+	cmp := map[string]func(a, b int) bool{
+		"==": func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		"<":  func(a, b int) bool { return a < b },
+		"<=": func(a, b int) bool { return a <= b },
+		">":  func(a, b int) bool { return a > b },
+		">=": func(a, b int) bool { return a >= b },
+	}[op]
+	return func(ev machine.StepEvent) bool {
+		got, _ := field(ev)
+		return cmp(got, n)
+	}, nil
+}
+
+// readSteps reads the steps a run wrote with --record or --trace-format
+// json, one JSON step event per line, and calls visit with each until it
+// returns false.
+func readSteps(path string, visit func(ev machine.StepEvent) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var ev machine.StepEvent
+		if err := dec.Decode(&ev); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if ev.Step == 0 {
+			continue // the result a --trace-format json trace ends with
+		}
+		if !visit(ev) {
+			return nil
+		}
+	}
+}
+
+// errFound ends a replay once a first-step query has its answer.
+var errFound = errors.New("found")
+
+// queryCmd answers a query about the steps of a run: replayed from the
+// rules file and tape, or read back from a --record file.
+func queryCmd(args []string) {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	tracePath := fs.String("trace", "", "read the steps from this `file`, written by a run with --record or --trace-format json, instead of replaying a run")
+	maxSteps := fs.Int("max-steps", 1000000, "give up the replay after this many steps")
+	limit := fs.Int("limit", 20, "most steps a steps query prints (0: all)")
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if *tracePath != "" && len(args) != 1 || *tracePath == "" && len(args) != 3 {
+		fmt.Println("Usage: go run . query [flags] <rules.txt> <#tape#> <query>")
+		fmt.Println("       go run . query --trace <steps.jsonl> <query>")
+		fs.PrintDefaults()
+		return
+	}
+	q, err := parseQuery(args[len(args)-1])
+	if err != nil {
+		fmt.Println("query error:", err)
+		os.Exit(2)
+	}
+
+	var (
+		count, shown int
+		last         *machine.StepEvent
+		done         bool
+	)
+	show := func(ev machine.StepEvent) {
+		if ev.Cells == "" {
+			fmt.Println(" ", stepRow(ev))
+			return
+		}
+		fmt.Println(" ", stepRow(ev), " ", machine.HighlightIndex(ev.Cells, ev.Head))
+	}
+	visit := func(ev machine.StepEvent) {
+		if done || !q.match(ev) {
+			return
+		}
+		count++
+		switch q.verb {
+		case "first":
+			show(ev)
+			done = true
+		case "last":
+			last = &ev
+		case "steps":
+			if *limit == 0 || shown < *limit {
+				show(ev)
+				shown++
+			}
+		}
+	}
+
+	if *tracePath != "" {
+		err := readSteps(*tracePath, func(ev machine.StepEvent) bool {
+			visit(ev)
+			return !done
+		})
+		if err != nil {
+			fmt.Println("trace error:", err)
+			os.Exit(2)
+		}
+	} else {
+		m, err := load(args[0], *strict, os.Stderr)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		tape, err := machine.ParseTape(args[1])
+		if err == nil {
+			err = m.CheckTape(tape)
+		}
+		if err != nil {
+			fmt.Println("tape error:", err)
+			os.Exit(2)
+		}
+		cfg := machine.Runtime{MaxSteps: *maxSteps, OnStep: visit}
+		cfg.Wait = func() error {
+			if done {
+				return errFound
+			}
+			return nil
+		}
+		runSilent(tape, m, cfg)
+	}
+
+	switch q.verb {
+	case "count":
+		fmt.Println(count)
+	case "last":
+		if last != nil {
+			show(*last)
+		}
+	case "steps":
+		if count > shown {
+			fmt.Printf("  ... and %d more (%d in all)\n", count-shown, count)
+		}
+	}
+	if count == 0 && q.verb != "count" {
+		fmt.Println("no step matches")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"project_twa/pkg/machine"
+)
+
+// randomSpec says what kind of machines randomRules makes.
+type randomSpec struct {
+	kind     machine.Kind
+	states   int     // working states; accept and reject states come on top
+	alphabet string  // input symbols
+	version  int     // 2: also per-pair directions and "*" pairs (2dfa)
+	missing  float64 // chance that a (state, symbol) has no pair
+	bounds   machine.BoundsPolicy
+}
+
+// randomRules writes a random machine that parses and validates: states
+// 1..n with random pairs into 1..n+2, n+1 accepting and n+2 rejecting.
+// The same seed always gives the same machine.
+func randomRules(w io.Writer, rng *rand.Rand, spec randomSpec, seed int64) {
+	n := spec.states
+	fmt.Fprintf(w, "// random %s, %d states over %q, seed %d\n", spec.kind, n, spec.alphabet, seed)
+	if spec.kind == machine.OneWay {
+		fmt.Fprintln(w, "kind: dfa")
+	} else if spec.version >= 2 {
+		fmt.Fprintln(w, "version: 2")
+	}
+	fmt.Fprintf(w, "alphabet: %s\n", spec.alphabet)
+	if spec.bounds != machine.BoundsError {
+		fmt.Fprintf(w, "on-bounds: %s\n", spec.bounds)
+	}
+
+	target := func() int { return 1 + rng.Intn(n+2) }
+	dirs := [...]string{"left", "right"}
+	for q := 1; q <= n; q++ {
+		var b strings.Builder
+		if spec.kind == machine.OneWay {
+			fmt.Fprintf(&b, "%d]", q)
+			for i := 0; i < len(spec.alphabet); i++ {
+				if rng.Float64() >= spec.missing {
+					fmt.Fprintf(&b, " (%c,%d)", spec.alphabet[i], target())
+				}
+			}
+			if !strings.Contains(b.String(), "(") {
+				fmt.Fprintf(&b, " (%c,%d)", spec.alphabet[0], target()) // a line needs at least one pair
+			}
+			if rng.Intn(2) == 0 {
+				fmt.Fprintf(&b, "\n%d] accept", q)
+			}
+			fmt.Fprintln(w, b.String())
+			continue
+		}
+
+		fmt.Fprintf(&b, "%d] %s", q, dirs[rng.Intn(2)])
+		syms := spec.alphabet + "#"
+		wild := spec.version >= 2 && rng.Intn(4) == 0
+		for i := 0; i < len(syms); i++ {
+			if rng.Float64() < spec.missing || wild && syms[i] != '#' && rng.Intn(2) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, " (%c,%d", syms[i], target())
+			if spec.version >= 2 && rng.Intn(3) == 0 {
+				b.WriteString("," + dirs[rng.Intn(2)])
+			}
+			b.WriteString(")")
+		}
+		if wild {
+			fmt.Fprintf(&b, " (*,%d)", target())
+		}
+		if !strings.Contains(b.String(), "(") {
+			fmt.Fprintf(&b, " (#,%d)", n+2) // a line needs at least one pair
+		}
+		fmt.Fprintln(w, b.String())
+	}
+	fmt.Fprintf(w, "%d] accept\n%d] reject\n", n+1, n+2)
+}
+
+// randomCmd prints random machines for shaking out engine bugs.
+func randomCmd(args []string) {
+	fs := flag.NewFlagSet("random", flag.ContinueOnError)
+	kind := fs.String("kind", "2dfa", "machine kind: 2dfa or dfa")
+	states := fs.Int("states", 5, "number of working states")
+	alphabet := fs.String("alphabet", "ab", "input symbols")
+	version := fs.Int("version", 1, "rules format version; 2 adds per-pair directions and * pairs")
+	missing := fs.Float64("missing", 0.1, "chance that a state has no pair for a symbol")
+	bounds := fs.String("on-bounds", "error", "what leaving the tape does: error, reject or clamp")
+	seed := fs.Int64("seed", 0, "random seed (default: from the clock, printed in the output)")
+	outPath := fs.String("o", "", "write the rules to this file instead of stdout")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	spec := randomSpec{states: *states, alphabet: *alphabet, version: *version, missing: *missing}
+	switch *kind {
+	case "2dfa":
+	case "dfa":
+		spec.kind = machine.OneWay
+	default:
+		args = append(args, "bad kind")
+	}
+	switch *bounds {
+	case "error":
+	case "reject":
+		spec.bounds = machine.BoundsReject
+	case "clamp":
+		spec.bounds = machine.BoundsClamp
+	default:
+		args = append(args, "bad on-bounds")
+	}
+	if len(args) != 0 || *states < 1 || *alphabet == "" || strings.ContainsAny(*alphabet, "#*(),!] \t") || *version < 1 || *version > machine.MaxVersion {
+		fmt.Println("Usage: go run . random [flags]")
+		fs.PrintDefaults()
+		return
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	w := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("random error:", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	randomRules(w, rand.New(rand.NewSource(*seed)), spec, *seed)
+}
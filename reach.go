@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// liveParts works out which states and transitions of m can take part in
+// a run. A transition fires only on a symbol the tape can hold: one of the
+// alphabet (any symbol if it is open), or '#', which a dfa never reads.
+// A state is reachable if firing transitions lead to it from the start.
+func liveParts(m *machine.Machine) (reach map[*machine.State]bool, fires func(sym byte) bool) {
+	alphabet, open := m.Alphabet()
+	fires = func(sym byte) bool {
+		if sym == '#' {
+			return m.Kind == machine.TwoWay
+		}
+		return open || strings.IndexByte(alphabet, sym) >= 0
+	}
+	// the wildcard fires if some readable symbol has no pair of its own
+	otherFires := func(s *machine.State) bool {
+		if open {
+			return true
+		}
+		syms := alphabet
+		if m.Kind == machine.TwoWay {
+			syms += "#"
+		}
+		for i := 0; i < len(syms); i++ {
+			if _, ok := s.Next[syms[i]]; !ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	reach = map[*machine.State]bool{m.Start: true}
+	for queue := []*machine.State{m.Start}; len(queue) > 0; queue = queue[1:] {
+		s := queue[0]
+		var to []*machine.State
+		for _, e := range s.Eps {
+			to = append(to, e.To)
+		}
+		for _, sym := range s.Order {
+			if fires(sym) {
+				for _, e := range s.EdgesOn(sym) {
+					to = append(to, e.To)
+				}
+			}
+		}
+		if s.Other != nil && otherFires(s) {
+			to = append(to, s.Other.To)
+			for _, e := range s.OtherAlts {
+				to = append(to, e.To)
+			}
+		}
+		for _, t := range to {
+			if t != nil && !reach[t] {
+				reach[t] = true
+				queue = append(queue, t)
+			}
+		}
+	}
+	return reach, fires
+}
+
+// pruneDead removes from m what liveParts finds dead: unreachable states
+// become nil entries of m.States, and transitions that never fire are
+// dropped. The machine accepts the same inputs, and an inferred alphabet
+// stays as it was, so the same tapes pass CheckTape.
+func pruneDead(m *machine.Machine) {
+	if alphabet, open := m.Alphabet(); !open {
+		m.DeclaredAlphabet = alphabet
+	}
+	reach, fires := liveParts(m)
+	for id, s := range m.States {
+		if !reach[s] {
+			m.States[id] = nil
+			continue
+		}
+		for _, sym := range append([]uint8(nil), s.Order...) {
+			if !fires(sym) {
+				s.RemoveEdge(sym)
+			}
+		}
+		if s.Other != nil && !reach[s.Other.To] {
+			s.Other, s.OtherAlts = nil, nil
+		}
+	}
+}
+
+func analyzeReach(m *machine.Machine, opt analyzeOptions) {
+	reach, fires := liveParts(m)
+	var unreachable, lostAccepts, dead []string
+	for _, s := range m.States {
+		if !s.Defined || s.Implicit {
+			continue
+		}
+		if !reach[s] {
+			unreachable = append(unreachable, fmt.Sprint(s.ID))
+			if s.Accept || s.Final {
+				lostAccepts = append(lostAccepts, fmt.Sprint(s.ID))
+			}
+			continue
+		}
+		for _, sym := range s.Order {
+			if !fires(sym) {
+				dead = append(dead, fmt.Sprintf("state %d on %q", s.ID, sym))
+			}
+		}
+	}
+
+	if len(unreachable)+len(dead) == 0 {
+		fmt.Println("every state is reachable from the start and every transition can fire")
+	}
+	if len(unreachable) > 0 {
+		fmt.Printf("unreachable states: %s (nothing leads there from state %d)\n", strings.Join(unreachable, ", "), m.Start.ID)
+	}
+	if len(lostAccepts) > 0 {
+		fmt.Printf("unreachable accept states: %s\n", strings.Join(lostAccepts, ", "))
+	}
+	if len(dead) > 0 {
+		why := "symbols outside the alphabet"
+		if m.Kind == machine.OneWay {
+			why = "symbols outside the alphabet, or '#', which a dfa never reads"
+		}
+		fmt.Printf("transitions that never fire (%s):\n", why)
+		for _, d := range dead {
+			fmt.Println(" ", d)
+		}
+	}
+
+	if opt.prune == "" {
+		return
+	}
+	f, err := os.Create(opt.prune)
+	if err != nil {
+		fmt.Println("analyze error:", err)
+		return
+	}
+	defer f.Close()
+	writeRules(f, m, reach, fires)
+	fmt.Printf("wrote the machine without them to %s\n", opt.prune)
+}
+
+// writeRules writes the states of m in keep, with the transitions on
+// symbols for which fires is true, as a rules file. The result accepts
+// the same inputs as m when keep and fires come from liveParts; comments
+// and layout of the original are not kept.
+func writeRules(w io.Writer, m *machine.Machine, keep map[*machine.State]bool, fires func(sym byte) bool) {
+	word := map[machine.Move]string{machine.L: "left", machine.R: "right"}
+	var lines []string
+	version, missing := 1, ""
+	for _, s := range m.States {
+		if !keep[s] || s.Implicit {
+			continue
+		}
+		switch {
+		case s.Accept:
+			lines = append(lines, fmt.Sprintf("%d] accept", s.ID))
+			continue
+		case s.Reject:
+			lines = append(lines, fmt.Sprintf("%d] reject", s.ID))
+			continue
+		}
+		pair := func(sym string, e machine.Edge) string {
+			if e.Dir != 0 {
+				version = 2
+				return fmt.Sprintf(" (%s,%d,%s)", sym, e.To.ID, word[e.Dir])
+			}
+			return fmt.Sprintf(" (%s,%d)", sym, e.To.ID)
+		}
+		var b strings.Builder
+		for _, e := range s.Eps {
+			b.WriteString(pair("ε", e))
+		}
+		for _, sym := range s.Order {
+			if fires(sym) {
+				for _, e := range s.EdgesOn(sym) {
+					b.WriteString(pair(string([]byte{sym}), e))
+				}
+			}
+		}
+		if e := s.Other; e != nil {
+			switch {
+			case e.Implicit && e.To == s:
+				missing = "ignore"
+			case e.Implicit:
+				missing = "reject-sink"
+			case keep[e.To]: // else it never fires
+				version = 2
+				b.WriteString(pair("*", *e))
+				for _, e := range s.OtherAlts {
+					b.WriteString(pair("*", e))
+				}
+			}
+		}
+		if b.Len() == 0 && missing == "ignore" {
+			// a line needs a pair; this one does what on-missing would
+			sym := "#"
+			if m.Kind == machine.OneWay && m.DeclaredAlphabet != "" {
+				sym = m.DeclaredAlphabet[:1] // a dfa never reads '#'
+			}
+			fmt.Fprintf(&b, " (%s,%d)", sym, s.ID)
+		}
+		switch {
+		case b.Len() > 0 && m.Kind == machine.OneWay:
+			lines = append(lines, fmt.Sprintf("%d]%s", s.ID, b.String()))
+		case b.Len() > 0:
+			lines = append(lines, fmt.Sprintf("%d] %s%s", s.ID, word[s.Dir], b.String()))
+		case !s.Final:
+			// nothing it reads takes it anywhere
+			lines = append(lines, fmt.Sprintf("%d] reject", s.ID))
+		}
+		if s.Final {
+			lines = append(lines, fmt.Sprintf("%d] accept", s.ID))
+		}
+	}
+
+	if version > 1 {
+		fmt.Fprintf(w, "version: %d\n", version)
+	}
+	if kind := m.KindName(); kind != "2dfa" {
+		fmt.Fprintln(w, "kind:", kind)
+	}
+	if m.DeclaredAlphabet != "" {
+		fmt.Fprintf(w, "alphabet: %s\n", m.DeclaredAlphabet)
+	}
+	switch {
+	case m.Bounce:
+		fmt.Fprintln(w, "endmarkers: bounce")
+	case m.OnBounds != machine.BoundsError:
+		fmt.Fprintf(w, "on-bounds: %s\n", m.OnBounds)
+	}
+	if missing != "" {
+		fmt.Fprintf(w, "on-missing: %s\n", missing)
+	}
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	for _, g := range m.Groups {
+		var ids []string
+		for _, id := range g.IDs {
+			if keep[m.States[id]] {
+				ids = append(ids, fmt.Sprint(id))
+			}
+		}
+		if len(ids) > 0 {
+			fmt.Fprintf(w, "@group %s %s\n", g.Name, strings.Join(ids, " "))
+		}
+	}
+}
+
+// components returns the strongly connected components of the graph of
+// states in keep, following the transitions that fire, in order of their
+// lowest id and each sorted by id (Tarjan's algorithm).
+func components(m *machine.Machine, keep map[*machine.State]bool, fires func(sym byte) bool) [][]*machine.State {
+	succ := func(s *machine.State) []*machine.State {
+		var out []*machine.State
+		for _, sym := range s.Order {
+			if e := s.Next[sym]; fires(sym) && keep[e.To] {
+				out = append(out, e.To)
+			}
+		}
+		if s.Other != nil && keep[s.Other.To] {
+			out = append(out, s.Other.To)
+		}
+		return out
+	}
+
+	index, low := map[*machine.State]int{}, map[*machine.State]int{}
+	onStack := map[*machine.State]bool{}
+	var stack []*machine.State
+	var comps [][]*machine.State
+	var visit func(s *machine.State)
+	visit = func(s *machine.State) {
+		index[s], low[s] = len(index), len(index)
+		stack = append(stack, s)
+		onStack[s] = true
+		for _, t := range succ(s) {
+			if _, seen := index[t]; !seen {
+				visit(t)
+				low[s] = min(low[s], low[t])
+			} else if onStack[t] {
+				low[s] = min(low[s], index[t])
+			}
+		}
+		if low[s] == index[s] {
+			var c []*machine.State
+			for {
+				t := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[t] = false
+				c = append(c, t)
+				if t == s {
+					break
+				}
+			}
+			sort.Slice(c, func(i, j int) bool { return c[i].ID < c[j].ID })
+			comps = append(comps, c)
+		}
+	}
+	for _, s := range m.States {
+		if _, seen := index[s]; keep[s] && !seen {
+			visit(s)
+		}
+	}
+	sort.Slice(comps, func(i, j int) bool { return comps[i][0].ID < comps[j][0].ID })
+	return comps
+}
+
+func analyzeCycles(m *machine.Machine, _ analyzeOptions) {
+	reach, fires := liveParts(m)
+	found := false
+	for _, c := range components(m, reach, fires) {
+		in := map[*machine.State]bool{}
+		for _, s := range c {
+			in[s] = true
+		}
+		// a single state is a cycle only with a transition to itself
+		exits, loops := false, len(c) > 1
+		for _, s := range c {
+			edges := make([]machine.Edge, 0, len(s.Next)+1)
+			for _, sym := range s.Order {
+				if fires(sym) {
+					edges = append(edges, s.Next[sym])
+				}
+			}
+			if s.Other != nil {
+				edges = append(edges, *s.Other)
+			}
+			for _, e := range edges {
+				loops = loops || e.To == s
+				exits = exits || !in[e.To]
+			}
+			exits = exits || s.Final
+		}
+		if !loops {
+			continue
+		}
+		found = true
+		ids := make([]string, len(c))
+		for i, s := range c {
+			ids[i] = fmt.Sprint(s.ID)
+		}
+		switch {
+		case exits:
+			fmt.Printf("cycle through states %s, with a way out\n", strings.Join(ids, ", "))
+		case m.Kind == machine.OneWay:
+			fmt.Printf("cycle through states %s, no way out: inputs that get there are rejected\n", strings.Join(ids, ", "))
+		default:
+			fmt.Printf("cycle through states %s, no way out: a run that gets there never accepts or rejects; it loops, gets stuck or leaves the tape (likely hang)\n", strings.Join(ids, ", "))
+		}
+	}
+	if !found {
+		fmt.Println("no cycles among the reachable states")
+	}
+}
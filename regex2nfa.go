@@ -0,0 +1,244 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// reFrag is a piece of a Thompson NFA: a start state and one final state
+// with no transitions out yet.
+type reFrag struct {
+	in, out *machine.State
+}
+
+// reParser parses a regular expression and builds its Thompson NFA as it
+// goes:
+//
+//	alt    = concat { "|" concat }
+//	concat = { repeat }               (nothing: the empty word)
+//	repeat = atom { "*" | "+" | "?" }
+//	atom   = symbol | "ε" | "(" alt ")"
+type reParser struct {
+	src    string
+	at     int
+	states []*machine.State // index 0 unused, as in a Machine
+	syms   map[byte]bool
+}
+
+func (p *reParser) state() *machine.State {
+	s := &machine.State{ID: len(p.states), Dir: machine.R, Defined: true}
+	p.states = append(p.states, s)
+	return s
+}
+
+func eps(from, to *machine.State) {
+	from.Eps = append(from.Eps, machine.Edge{To: to})
+}
+
+func (p *reParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.at+1, fmt.Sprintf(format, args...))
+}
+
+func (p *reParser) alt() (reFrag, error) {
+	f, err := p.concat()
+	if err != nil {
+		return f, err
+	}
+	for p.at < len(p.src) && p.src[p.at] == '|' {
+		p.at++
+		g, err := p.concat()
+		if err != nil {
+			return g, err
+		}
+		u := reFrag{p.state(), p.state()}
+		eps(u.in, f.in)
+		eps(u.in, g.in)
+		eps(f.out, u.out)
+		eps(g.out, u.out)
+		f = u
+	}
+	return f, nil
+}
+
+func (p *reParser) concat() (reFrag, error) {
+	var f *reFrag
+	for p.at < len(p.src) && p.src[p.at] != '|' && p.src[p.at] != ')' {
+		g, err := p.repeat()
+		if err != nil {
+			return g, err
+		}
+		if f == nil {
+			f = &g
+			continue
+		}
+		eps(f.out, g.in)
+		f.out = g.out
+	}
+	if f == nil {
+		e := reFrag{p.state(), p.state()}
+		eps(e.in, e.out)
+		return e, nil
+	}
+	return *f, nil
+}
+
+func (p *reParser) repeat() (reFrag, error) {
+	f, err := p.atom()
+	if err != nil {
+		return f, err
+	}
+	for p.at < len(p.src) && strings.IndexByte("*+?", p.src[p.at]) >= 0 {
+		op := p.src[p.at]
+		p.at++
+		r := reFrag{p.state(), p.state()}
+		eps(r.in, f.in)
+		if op != '+' {
+			eps(r.in, r.out) // skip it
+		}
+		if op != '?' {
+			eps(f.out, f.in) // again
+		}
+		eps(f.out, r.out)
+		f = r
+	}
+	return f, nil
+}
+
+func (p *reParser) atom() (reFrag, error) {
+	c := p.src[p.at]
+	switch {
+	case c == '(':
+		p.at++
+		f, err := p.alt()
+		if err != nil {
+			return f, err
+		}
+		if p.at >= len(p.src) || p.src[p.at] != ')' {
+			return f, p.errorf("missing )")
+		}
+		p.at++
+		return f, nil
+	case strings.HasPrefix(p.src[p.at:], "ε"):
+		p.at += len("ε")
+		f := reFrag{p.state(), p.state()}
+		eps(f.in, f.out)
+		return f, nil
+	case strings.IndexByte("*+?", c) >= 0:
+		return reFrag{}, p.errorf("%c repeats nothing", c)
+	case strings.IndexByte("#(),!_]", c) >= 0 || c <= ' ' || c >= 0x80:
+		return reFrag{}, p.errorf("%q cannot be an input symbol", c)
+	}
+	p.at++
+	p.syms[c] = true
+	f := reFrag{p.state(), p.state()}
+	f.in.SetEdge(c, machine.Edge{To: f.out})
+	return f, nil
+}
+
+// regexNFA builds the Thompson NFA of re as a machine of kind nfa, its
+// states numbered in the order a breadth-first walk from the start meets
+// them. alphabet, if not empty, is declared; it must hold every symbol of
+// re.
+func regexNFA(re, alphabet string) (*machine.Machine, error) {
+	p := &reParser{src: re, states: []*machine.State{nil}, syms: map[byte]bool{}}
+	f, err := p.alt()
+	if err != nil {
+		return nil, err
+	}
+	if p.at < len(p.src) {
+		return nil, p.errorf("unmatched )")
+	}
+	f.out.Final = true
+
+	order := []*machine.State{nil, f.in}
+	seen := map[*machine.State]bool{f.in: true}
+	for k := 1; k < len(order); k++ {
+		s := order[k]
+		next := append([]machine.Edge(nil), s.Eps...)
+		for _, sym := range s.Order {
+			next = append(next, s.EdgesOn(sym)...)
+		}
+		for _, e := range next {
+			if !seen[e.To] {
+				seen[e.To] = true
+				order = append(order, e.To)
+			}
+		}
+	}
+	for id, s := range order[1:] {
+		s.ID = id + 1
+	}
+
+	syms := make([]byte, 0, len(p.syms))
+	for c := range p.syms {
+		syms = append(syms, c)
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i] < syms[j] })
+	if alphabet == "" {
+		alphabet = string(syms)
+	}
+	for _, c := range syms {
+		if strings.IndexByte(alphabet, c) < 0 {
+			return nil, fmt.Errorf("%q is not in the alphabet %q", c, alphabet)
+		}
+	}
+	return machine.NewMachine(machine.OneWay, order, f.in,
+		machine.WithNondet(true),
+		machine.WithAlphabet(alphabet),
+	), nil
+}
+
+// regex2nfaCmd writes the rules file of an nfa for a regular expression,
+// by Thompson's construction, and draws it.
+func regex2nfaCmd(args []string) {
+	fs := flag.NewFlagSet("regex2nfa", flag.ContinueOnError)
+	alphabet := fs.String("alphabet", "", "input symbols of the nfa (default: those in the expression)")
+	outPath := fs.String("o", "", "write the rules to this file instead of stdout")
+	dotPath := fs.String("dot", "regex.dot", "write the nfa as DOT to this `file`")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: go run . regex2nfa [flags] <regex>")
+		fmt.Println("(| union, juxtaposition, * + ? repetition, ( ) grouping, ε the empty word)")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := regexNFA(args[0], *alphabet)
+	if err != nil {
+		fmt.Println("regex error:", err)
+		os.Exit(2)
+	}
+
+	w, notes := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("output error:", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		w, notes = f, os.Stdout
+	}
+	keep := map[*machine.State]bool{}
+	for _, s := range m.States[1:] {
+		keep[s] = true
+	}
+	fmt.Fprintf(w, "// Accepts %s (Thompson's construction).\n", args[0])
+	writeRules(w, m, keep, func(byte) bool { return true })
+	if err := writeDOT(m, *dotPath); err != nil {
+		fmt.Println("dot error:", err)
+		os.Exit(2)
+	}
+	if *outPath != "" {
+		fmt.Fprintln(notes, "wrote", *outPath)
+	}
+	fmt.Fprintf(notes, "%d states; DOT saved to: %s\n", len(m.States)-1, *dotPath)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"project_twa/pkg/machine"
+)
+
+const replHelp = `  #tape#             set the tape and run it
+  :run               run the tape again (also an empty line)
+  :tape              show the tape with cell numbers
+  :ins <cell> <syms> insert symbols before a cell
+  :del <cell> [n]    delete n cells (default 1) from a cell on
+  :rep <cell> <syms> overwrite cells from a cell on
+  :reload            read the rules file again
+  :history           list the runs of this session
+  :export <file>     save the runs of this session as JSON
+  :help              this list
+  :quit              leave (also end of input)
+Cells are numbered like head positions: 0 is the left '#'. Edits keep
+both endmarkers.`
+
+// replSession is the state kept between the lines of a repl: the machine,
+// where it came from, the tape being explored and the runs so far.
+type replSession struct {
+	path    string
+	strict  bool
+	m       *machine.Machine
+	tape    string
+	cfg     machine.Runtime
+	history []machine.Result
+}
+
+// Session is what :export writes: the rules file and every run of a repl
+// session in order, each as a run's --json result.
+type Session struct {
+	Schema int              `json:"schema"`
+	Rules  string           `json:"rules"`
+	Runs   []machine.Result `json:"runs"`
+}
+
+// showTape prints the tape over a ruler of cell numbers (mod 10).
+func (rs *replSession) showTape() {
+	var ruler strings.Builder
+	for i := range rs.tape {
+		ruler.WriteByte('0' + byte(i%10))
+	}
+	fmt.Printf("  %s\n  %s\n", rs.tape, ruler.String())
+}
+
+func (rs *replSession) run() {
+	if err := rs.m.CheckTape(rs.tape); err != nil {
+		fmt.Println("tape error:", err)
+		return
+	}
+	res := runSilent(rs.tape, rs.m, rs.cfg)
+	rs.history = append(rs.history, res)
+	fmt.Printf("%s => %s in %d steps", rs.tape, strings.ToUpper(res.Outcome.String()), res.Steps)
+	if !res.Accepted {
+		fmt.Printf(": %s", res.Reason)
+	}
+	fmt.Println()
+}
+
+func (rs *replSession) showHistory() {
+	if len(rs.history) == 0 {
+		fmt.Println("no runs yet")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tTAPE\tOUTCOME\tSTEPS")
+	for k, res := range rs.history {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\n", k+1, res.Tape, res.Outcome, res.Steps)
+	}
+	tw.Flush()
+}
+
+func (rs *replSession) export(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Session{Schema: machine.SchemaVersion, Rules: rs.path, Runs: rs.history})
+}
+
+// edit applies :ins, :del or :rep to the tape. Only the cells between the
+// endmarkers can change; :ins may insert before the right one.
+func (rs *replSession) edit(op string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s needs a cell", op)
+	}
+	cell, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("cell %q is not a number", args[0])
+	}
+	last := len(rs.tape) - 2 // the last cell between the endmarkers
+	if op == ":ins" {
+		last++
+	}
+	if last < 1 {
+		return fmt.Errorf("there are no cells between the endmarkers")
+	}
+	if cell < 1 || cell > last {
+		return fmt.Errorf("cell %d is outside 1..%d", cell, last)
+	}
+
+	switch op {
+	case ":ins", ":rep":
+		if len(args) != 2 {
+			return fmt.Errorf("%s needs a cell and symbols", op)
+		}
+		syms := args[1]
+		end := cell
+		if op == ":rep" {
+			if end = cell + len(syms); end > last+1 {
+				return fmt.Errorf("%d symbols from cell %d would overwrite the right '#'", len(syms), cell)
+			}
+		}
+		rs.tape = rs.tape[:cell] + syms + rs.tape[end:]
+	case ":del":
+		n := 1
+		if len(args) == 2 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("count %q is not a positive number", args[1])
+			}
+		}
+		rs.tape = rs.tape[:cell] + rs.tape[min(cell+n, last+1):]
+	}
+	return nil
+}
+
+// replCmd explores a machine interactively: the tape can be set, edited
+// cell by cell and run again, and the rules reloaded, without restarting.
+func replCmd(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	maxSteps := fs.Int("max-steps", 10000, "step bound for each run")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("Usage: go run . repl [flags] <rules.txt> [\"#tape#\"]")
+		fs.PrintDefaults()
+		return
+	}
+	m, err := load(args[0], *strict, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	rs := &replSession{path: args[0], strict: *strict, m: m, tape: "##", cfg: machine.Runtime{MaxSteps: *maxSteps}, history: []machine.Result{}}
+	if len(args) == 2 {
+		if rs.tape, err = machine.ParseTape(args[1]); err != nil {
+			fmt.Println("tape error:", err)
+			return
+		}
+		rs.run()
+	}
+
+	prompt := func() {
+		if isTerminal(os.Stdin) {
+			fmt.Print("> ")
+		}
+	}
+	sc := bufio.NewScanner(os.Stdin)
+	for prompt(); sc.Scan(); prompt() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "#") {
+			tape, err := machine.ParseTape(line)
+			if err != nil {
+				fmt.Println("tape error:", err)
+				continue
+			}
+			rs.tape = tape
+			rs.run()
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			rs.run()
+			continue
+		}
+		switch op := fields[0]; op {
+		case ":run":
+			rs.run()
+		case ":tape":
+			rs.showTape()
+		case ":ins", ":del", ":rep":
+			if err := rs.edit(op, fields[1:]); err != nil {
+				fmt.Println("edit error:", err)
+				continue
+			}
+			rs.showTape()
+		case ":reload":
+			m, err := load(rs.path, rs.strict, os.Stdout)
+			if err != nil {
+				fmt.Println(err, "(keeping the machine loaded before)")
+				continue
+			}
+			rs.m = m
+			fmt.Println("reloaded", rs.path)
+		case ":history":
+			rs.showHistory()
+		case ":export":
+			if len(fields) != 2 {
+				fmt.Println("export error: :export needs a file name")
+				continue
+			}
+			if err := rs.export(fields[1]); err != nil {
+				fmt.Println("export error:", err)
+				continue
+			}
+			fmt.Printf("wrote %d runs to %s\n", len(rs.history), fields[1])
+		case ":help":
+			fmt.Println(replHelp)
+		case ":quit", ":q":
+			return
+		default:
+			fmt.Printf("unknown command %q; :help lists them\n", op)
+		}
+	}
+}
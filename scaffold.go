@@ -0,0 +1,321 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dfaSpec describes a one-way DFA to write out as rules: states 0..n-1,
+// 0 the start.
+type dfaSpec struct {
+	about    string // what the machine accepts
+	alphabet string
+	n        int
+	delta    func(q int, c byte) int
+	accept   func(q int) bool
+	note     func(q int) string // what being in q means
+}
+
+// scaffolds are the languages "scaffold" can generate; each builds a spec
+// from its arguments and the alphabet.
+var scaffolds = map[string]struct {
+	usage string
+	build func(args []string, alphabet string) (*dfaSpec, error)
+}{
+	"contains":    {"<word>", scaffoldContains},
+	"starts-with": {"<word>", scaffoldStartsWith},
+	"ends-with":   {"<word>", scaffoldEndsWith},
+	"count-mod":   {"<sym> <k> <r>", scaffoldCountMod},
+	"div":         {"<n>", scaffoldDiv},
+}
+
+// nonRegular names classic languages no finite automaton, one-way or
+// two-way, recognizes.
+var nonRegular = map[string]string{
+	"anbn":       "a^n b^n",
+	"palindrome": "palindromes",
+	"equal-ab":   "words with as many a as b",
+	"ww":         "words of the form ww",
+}
+
+func scaffoldCmd(args []string) {
+	fs := flag.NewFlagSet("scaffold", flag.ContinueOnError)
+	kind := fs.String("kind", "2dfa", "machine kind to write: 2dfa or dfa")
+	alphabet := fs.String("alphabet", "ab", "input symbols (div always uses 01)")
+	outPath := fs.String("o", "", "write the rules to this file instead of stdout")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) > 0 && nonRegular[args[0]] != "" {
+		fmt.Printf("%s (%s) is not a regular language, so no 2dfa or dfa recognizes it\n", args[0], nonRegular[args[0]])
+		os.Exit(1)
+	}
+	if len(args) == 0 || scaffolds[args[0]].build == nil || (*kind != "2dfa" && *kind != "dfa") {
+		names := make([]string, 0, len(scaffolds))
+		for name := range scaffolds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("Usage: go run . scaffold [flags] <language> [args]")
+		for _, name := range names {
+			fmt.Printf("  %s %s\n", name, scaffolds[name].usage)
+		}
+		fs.PrintDefaults()
+		return
+	}
+
+	for i := 0; i < len(*alphabet); i++ {
+		if c := (*alphabet)[i]; strings.IndexByte("#*(),! \t", c) >= 0 || strings.IndexByte((*alphabet)[:i], c) >= 0 {
+			fmt.Printf("scaffold error: %q cannot be listed in the alphabet\n", c)
+			return
+		}
+	}
+	spec, err := scaffolds[args[0]].build(args[1:], *alphabet)
+	if err != nil {
+		fmt.Println("scaffold error:", err)
+		return
+	}
+	w := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("scaffold error:", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	writeScaffold(w, spec, *kind == "dfa")
+}
+
+// writeScaffold writes spec as a commented rules file. States that loop on
+// every symbol become halting accept/reject lines where the kind allows; a
+// 2dfa reads on to the right endmarker and decides there.
+func writeScaffold(w io.Writer, spec *dfaSpec, oneWay bool) {
+	sink := func(q int) bool {
+		for i := 0; i < len(spec.alphabet); i++ {
+			if spec.delta(q, spec.alphabet[i]) != q {
+				return false
+			}
+		}
+		return true
+	}
+	id := func(q int) int { return q + 1 }
+
+	fmt.Fprintf(w, "// Accepts %s.\n", spec.about)
+	if oneWay {
+		fmt.Fprintln(w, "kind: dfa")
+	}
+	fmt.Fprintf(w, "alphabet: %s\n", spec.alphabet)
+
+	// 2dfa: where the right endmarker leads from accepting and other states
+	accID, rejID := 0, 0
+	if !oneWay {
+		for q := 0; q < spec.n; q++ {
+			if sink(q) && spec.accept(q) && accID == 0 {
+				accID = id(q)
+			}
+			if sink(q) && !spec.accept(q) && rejID == 0 {
+				rejID = id(q)
+			}
+		}
+		next := spec.n + 1
+		if accID == 0 {
+			accID, next = next, next+1
+		}
+		if rejID == 0 {
+			rejID = next
+		}
+	}
+
+	for q := 0; q < spec.n; q++ {
+		fmt.Fprintf(w, "\n// %d: %s\n", id(q), spec.note(q))
+		switch {
+		case sink(q) && !spec.accept(q):
+			fmt.Fprintf(w, "%d] reject\n", id(q))
+			continue
+		case sink(q) && !oneWay:
+			fmt.Fprintf(w, "%d] accept\n", id(q))
+			continue
+		}
+		var b strings.Builder
+		for i := 0; i < len(spec.alphabet); i++ {
+			fmt.Fprintf(&b, " (%c,%d)", spec.alphabet[i], id(spec.delta(q, spec.alphabet[i])))
+		}
+		if oneWay {
+			fmt.Fprintf(w, "%d]%s\n", id(q), b.String())
+			if spec.accept(q) {
+				fmt.Fprintf(w, "%d] accept\n", id(q))
+			}
+			continue
+		}
+		end := rejID
+		if spec.accept(q) {
+			end = accID
+		}
+		fmt.Fprintf(w, "%d] right%s (#,%d)\n", id(q), b.String(), end)
+	}
+	if !oneWay {
+		if accID > spec.n {
+			fmt.Fprintf(w, "\n// %d: the input ended in an accepting state\n%d] accept\n", accID, accID)
+		}
+		if rejID > spec.n {
+			fmt.Fprintf(w, "\n// %d: the input ended in any other state\n%d] reject\n", rejID, rejID)
+		}
+	}
+}
+
+// checkWord makes sure the scaffolds' single argument is a word over
+// alphabet.
+func checkWord(args []string, alphabet string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", fmt.Errorf("expect one word")
+	}
+	for i := 0; i < len(args[0]); i++ {
+		if strings.IndexByte(alphabet, args[0][i]) < 0 {
+			return "", fmt.Errorf("%q is not in the alphabet %q", args[0][i:i+1], alphabet)
+		}
+	}
+	return args[0], nil
+}
+
+// overlap is the length of the longest prefix of word that ends s.
+func overlap(word, s string) int {
+	for k := min(len(word), len(s)); k > 0; k-- {
+		if strings.HasSuffix(s, word[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+func scaffoldContains(args []string, alphabet string) (*dfaSpec, error) {
+	word, err := checkWord(args, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	m := len(word)
+	return &dfaSpec{
+		about:    fmt.Sprintf("words containing %q", word),
+		alphabet: alphabet,
+		n:        m + 1,
+		delta: func(q int, c byte) int {
+			if q == m {
+				return m
+			}
+			return overlap(word, word[:q]+string(c))
+		},
+		accept: func(q int) bool { return q == m },
+		note: func(q int) string {
+			if q == m {
+				return fmt.Sprintf("%q seen", word)
+			}
+			return fmt.Sprintf("the input ends in %q, the longest start of %q so far", word[:q], word)
+		},
+	}, nil
+}
+
+func scaffoldStartsWith(args []string, alphabet string) (*dfaSpec, error) {
+	word, err := checkWord(args, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	m := len(word)
+	dead := m + 1
+	return &dfaSpec{
+		about:    fmt.Sprintf("words starting with %q", word),
+		alphabet: alphabet,
+		n:        m + 2,
+		delta: func(q int, c byte) int {
+			switch {
+			case q >= m:
+				return q
+			case word[q] == c:
+				return q + 1
+			}
+			return dead
+		},
+		accept: func(q int) bool { return q == m },
+		note: func(q int) string {
+			switch {
+			case q == m:
+				return fmt.Sprintf("%q read", word)
+			case q == dead:
+				return fmt.Sprintf("the input does not start with %q", word)
+			}
+			return fmt.Sprintf("%q read so far", word[:q])
+		},
+	}, nil
+}
+
+func scaffoldEndsWith(args []string, alphabet string) (*dfaSpec, error) {
+	word, err := checkWord(args, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	m := len(word)
+	return &dfaSpec{
+		about:    fmt.Sprintf("words ending in %q", word),
+		alphabet: alphabet,
+		n:        m + 1,
+		delta: func(q int, c byte) int {
+			return overlap(word, word[:q]+string(c))
+		},
+		accept: func(q int) bool { return q == m },
+		note: func(q int) string {
+			return fmt.Sprintf("the input ends in %q, the longest start of %q so far", word[:q], word)
+		},
+	}, nil
+}
+
+func scaffoldCountMod(args []string, alphabet string) (*dfaSpec, error) {
+	if len(args) != 3 || len(args[0]) != 1 || strings.IndexByte(alphabet, args[0][0]) < 0 {
+		return nil, fmt.Errorf("expect a symbol of the alphabet, a modulus and a remainder")
+	}
+	sym := args[0][0]
+	k, err := strconv.Atoi(args[1])
+	if err != nil || k < 1 {
+		return nil, fmt.Errorf("modulus must be a positive number, got %q", args[1])
+	}
+	r, err := strconv.Atoi(args[2])
+	if err != nil || r < 0 || r >= k {
+		return nil, fmt.Errorf("remainder must be 0 to %d, got %q", k-1, args[2])
+	}
+	return &dfaSpec{
+		about:    fmt.Sprintf("words whose number of %c is %d mod %d", sym, r, k),
+		alphabet: alphabet,
+		n:        k,
+		delta: func(q int, c byte) int {
+			if c == sym {
+				return (q + 1) % k
+			}
+			return q
+		},
+		accept: func(q int) bool { return q == r },
+		note:   func(q int) string { return fmt.Sprintf("%d %c so far, mod %d", q, sym, k) },
+	}, nil
+}
+
+func scaffoldDiv(args []string, _ string) (*dfaSpec, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expect a divisor")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("divisor must be a positive number, got %q", args[0])
+	}
+	return &dfaSpec{
+		about:    fmt.Sprintf("binary numbers, most significant bit first, divisible by %d", n),
+		alphabet: "01",
+		n:        n,
+		delta:    func(q int, c byte) int { return (2*q + int(c-'0')) % n },
+		accept:   func(q int) bool { return q == 0 },
+		note:     func(q int) string { return fmt.Sprintf("the bits so far are %d mod %d", q, n) },
+	}, nil
+}
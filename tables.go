@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"project_twa/pkg/machine"
+)
+
+// tableRuntime is the interpreter the exported tables share: the Table
+// type, its typed constants and one Accepts method, with the same rules
+// as the function codegen writes.
+const tableRuntime = `// Target is an entry of Table.Next: the state to go to, or one of the
+// constants below.
+type Target int32
+
+const (
+	TargetStuck  Target = 0 // no transition: the input is not accepted
+	TargetAccept Target = -1
+	TargetReject Target = -2
+)
+
+// Step is an entry of Table.Move: where the head goes.
+type Step int8
+
+const (
+	StepLeft  Step = -1
+	StepStay  Step = 0
+	StepRight Step = 1
+)
+
+// Table is a compiled machine. Column maps a tape byte to a column of
+// Next and Move, with 0 for any byte the machine does not name.
+type Table struct {
+	Column [256]uint8
+	Open   bool // bytes in column 0 are input symbols too
+	Bounce bool // '#' may not appear in the input
+	Clamp  bool // the head stays on the tape instead of falling off
+	OneWay bool // a dfa: Final decides at the right endmarker
+	Start  Target
+	Next   [][]Target // by state, then column
+	Move   [][]Step
+	Final  []bool
+}
+
+// Accepts reports whether the machine in t accepts input, run on the tape
+// "#" + input + "#". A run that loops is not accepted.
+func (t *Table) Accepts(input string) bool {
+	for i := 0; i < len(input); i++ {
+		if !t.Open && t.Column[input[i]] == 0 || t.Bounce && input[i] == '#' {
+			return false
+		}
+	}
+	tape := "#" + input + "#"
+	seen := make([]bool, len(t.Next)*len(tape)) // (state, head) pairs: a repeat is a loop
+	q, i := int(t.Start), 1
+	for {
+		if i < 0 || i >= len(tape) {
+			if !t.Clamp {
+				return false // off the tape
+			}
+			if i < 0 {
+				i = 0
+			} else {
+				i = len(tape) - 1
+			}
+		}
+		if t.OneWay && i == len(tape)-1 {
+			return t.Final[q]
+		}
+		if seen[q*len(tape)+i] {
+			return false
+		}
+		seen[q*len(tape)+i] = true
+		c := t.Column[tape[i]]
+		switch next := t.Next[q][c]; next {
+		case TargetStuck, TargetReject:
+			return false
+		case TargetAccept:
+			return true
+		default:
+			q, i = int(next), i+int(t.Move[q][c])
+		}
+	}
+}
+`
+
+// tableName makes an exported Go name from a rules file name:
+// "even-as.txt" becomes EvenAs.
+func tableName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(base, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		r := []rune(part)
+		b.WriteString(string(unicode.ToUpper(r[0])) + string(r[1:]))
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "M" + name
+	}
+	return name
+}
+
+// goTable writes m as a Table value named name, for the runtime above.
+func goTable(b *strings.Builder, m *machine.Machine, from, name string) {
+	t := compile(m)
+	ct := cutTable(m, t)
+	target := map[int32]string{tabAccept: "TargetAccept", tabReject: "TargetReject"}
+	step := map[int8]string{-1: "StepLeft", 0: "StepStay", 1: "StepRight"}
+
+	fmt.Fprintf(b, "// %s is the %s in %s.\n", name, m.Kind, from)
+	fmt.Fprintf(b, "var %s = Table{\n", name)
+	b.WriteString("\tColumn: [256]uint8{")
+	for k := 0; k < len(ct.cols); k++ {
+		fmt.Fprintf(b, "%q: %d, ", rune(ct.cols[k]), k+1)
+	}
+	b.WriteString("},\n")
+	fmt.Fprintf(b, "\tOpen: %t,\n\tBounce: %t,\n\tClamp: %t,\n\tOneWay: %t,\n", ct.open, m.Bounce, t.bounds == machine.BoundsClamp, t.oneWay)
+	fmt.Fprintf(b, "\tStart: %d,\n", t.start)
+	b.WriteString("\tNext: [][]Target{\n")
+	for _, row := range ct.next {
+		b.WriteString("\t\t{")
+		for _, next := range row {
+			if s, ok := target[next]; ok {
+				fmt.Fprintf(b, "%s, ", s)
+			} else {
+				fmt.Fprintf(b, "%d, ", next)
+			}
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("\t},\n\tMove: [][]Step{\n")
+	for _, row := range ct.move {
+		b.WriteString("\t\t{")
+		for _, mv := range row {
+			fmt.Fprintf(b, "%s, ", step[mv])
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("\t},\n")
+	if t.oneWay {
+		fmt.Fprintf(b, "\tFinal: %#v,\n", t.final)
+	}
+	b.WriteString("}\n")
+}
+
+// tablesCmd writes machines as Table values plus, unless told otherwise,
+// the one interpreter they share: lighter than a codegen function per
+// machine when a program embeds several.
+func tablesCmd(args []string) {
+	fs := flag.NewFlagSet("tables", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules files as errors")
+	outPath := fs.String("o", "", "write the Go source to this file instead of stdout")
+	pkg := fs.String("package", "main", "package clause of the generated file")
+	name := fs.String("name", "", "name of the Table value, with a single rules file (default: from the file name)")
+	runtime := fs.Bool("runtime", true, "include the Table type and its interpreter; turn off for further files of the same package")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) == 0 && !*runtime || *name != "" && len(args) != 1 || !token.IsIdentifier(*pkg) {
+		fmt.Println("Usage: go run . tables [flags] [rules.txt...]")
+		fs.PrintDefaults()
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by \"go run . tables %s\"; DO NOT EDIT.\n\n", strings.Join(args, " "))
+	fmt.Fprintf(&b, "package %s\n\n", *pkg)
+	if *runtime {
+		b.WriteString(tableRuntime)
+	}
+	names := map[string]string{}
+	for _, path := range args {
+		m, err := loadDeterministic(path, *strict, os.Stderr)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			return
+		}
+		n := *name
+		if n == "" {
+			n = tableName(path)
+		}
+		if !token.IsIdentifier(n) {
+			fmt.Printf("tables error: %q is not a Go name; pick one with --name\n", n)
+			return
+		}
+		if prev, ok := names[n]; ok {
+			fmt.Printf("tables error: %s and %s would both be named %s\n", prev, path, n)
+			return
+		}
+		names[n] = path
+		b.WriteString("\n")
+		goTable(&b, m, path, n)
+	}
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		fmt.Println("tables error:", err)
+		return
+	}
+
+	w := io.Writer(os.Stdout)
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Println("tables error:", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(src); err != nil {
+		fmt.Println("tables error:", err)
+	}
+}
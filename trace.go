@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"project_twa/pkg/machine"
+)
+
+type tracer struct {
+	w       io.Writer
+	tmpl    *template.Template // nil: the built-in multi-line format
+	color   bool               // ANSI colors in the built-in format
+	box     bool               // draw the tape as a ruled box of cells
+	inPlace bool               // redraw the box over the previous one
+	drawn   int                // lines written by the last box
+	quiet   bool               // no trace
+	enc     *json.Encoder      // JSON lines, one per step, instead of text; or nil
+	prog    *progress          // status line for runs nobody watches, or nil
+}
+
+// progress keeps a single status line updated about once a second while a
+// quiet run goes on, so long runs do not look hung.
+type progress struct {
+	w     io.Writer
+	start time.Time
+	last  time.Time
+	shown bool
+}
+
+func newProgress(w io.Writer) *progress {
+	now := time.Now()
+	return &progress{w: w, start: now, last: now}
+}
+
+func (p *progress) update(ev machine.StepEvent) {
+	if ev.Step%1024 != 0 {
+		return
+	}
+	if now := time.Now(); now.Sub(p.last) >= time.Second {
+		p.last = now
+		p.shown = true
+		fmt.Fprintf(p.w, "\r\x1b[Kstep %d  head %d  elapsed %s", ev.Step, ev.NewHead, now.Sub(p.start).Round(time.Second))
+	}
+}
+
+func (p *progress) clear() {
+	if p.shown {
+		fmt.Fprint(p.w, "\r\x1b[K")
+		p.shown = false
+	}
+}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiInverse = "\x1b[7m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+)
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor reports whether f should get ANSI colors: it must be a terminal,
+// and NO_COLOR (https://no-color.org) must be unset.
+func useColor(f *os.File) bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+func newTracer(w io.Writer, tmpl string, color bool) (*tracer, error) {
+	tr := &tracer{w: w, color: color}
+	if tmpl == "" {
+		return tr, nil
+	}
+	if !strings.HasSuffix(tmpl, "\n") {
+		tmpl += "\n"
+	}
+	t, err := template.New("trace").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	tr.tmpl = t
+	return tr, nil
+}
+
+func (tr *tracer) begin() {
+	if tr.quiet || tr.enc != nil {
+		return
+	}
+	fmt.Fprintln(tr.w, "== TRACE START ==")
+}
+
+func (tr *tracer) end() {
+	if tr.prog != nil {
+		tr.prog.clear()
+	}
+}
+
+func (tr *tracer) step(ev machine.StepEvent) {
+	if tr.prog != nil {
+		tr.prog.update(ev)
+	}
+	if tr.quiet {
+		return
+	}
+	if tr.enc != nil {
+		tr.enc.Encode(ev)
+		return
+	}
+	if tr.tmpl != nil {
+		if err := tr.tmpl.Execute(tr.w, ev); err != nil {
+			fmt.Fprintln(tr.w, "trace template:", err)
+		}
+		return
+	}
+	if tr.box {
+		tr.boxStep(ev)
+		return
+	}
+	fmt.Fprintf(tr.w, "=============================================\n")
+	fmt.Fprintln(tr.w, "Tape :", tr.tapeView(ev.Cells, ev.Head))
+	fmt.Fprintf(tr.w, "step  state       read  next  move  head    line\n")
+	fmt.Fprintln(tr.w, tr.paintStatus(ev.Status, stepRow(ev)))
+}
+
+// stepRow is a step as a trace row; its line column is "-" for a
+// transition no rules-file line wrote.
+func stepRow(ev machine.StepEvent) string {
+	line := "-"
+	if ev.Line > 0 {
+		line = strconv.Itoa(ev.Line)
+	}
+	return fmt.Sprintf("%-5d %-10s  %-4s  %-4d  %-4s  %-6s  %s",
+		ev.Step,
+		fmt.Sprintf("%d(%s)", ev.State, ev.Dir),
+		ev.Read,
+		ev.Next,
+		ev.Move,
+		fmt.Sprintf("%d->%d", ev.Head, ev.NewHead),
+		line,
+	)
+}
+
+// lineRuns writes the rules-file lines of a run's steps, a line taken
+// several times in a row once with its count, and a transition no line
+// wrote (added by an on-missing fallback) as "-": "2 3×4 5 -".
+func lineRuns(lines []int) string {
+	var parts []string
+	for k := 0; k < len(lines); {
+		n := 1
+		for k+n < len(lines) && lines[k+n] == lines[k] {
+			n++
+		}
+		p := "-"
+		if lines[k] > 0 {
+			p = strconv.Itoa(lines[k])
+		}
+		if n > 1 {
+			p += "×" + strconv.Itoa(n)
+		}
+		parts = append(parts, p)
+		k += n
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// boxStep draws the tape as ruled cells with their indices underneath and
+// an arrow under the head:
+//
+//	┌───┬───┬───┐
+//	│ # │ b │ # │
+//	└───┴───┴───┘
+//	  0   1   2
+//	      ↑
+func (tr *tracer) boxStep(ev machine.StepEvent) {
+	n := len(ev.Cells)
+	w := max(3, len(strconv.Itoa(n-1))+2)
+	rule := strings.Repeat("─", w)
+
+	var b strings.Builder
+	b.WriteString("step  state       read  next  move  head    line\n")
+	b.WriteString(tr.paintStatus(ev.Status, stepRow(ev)) + "\n")
+	b.WriteString("┌" + strings.Repeat(rule+"┬", n-1) + rule + "┐\n")
+	for i := 0; i < n; i++ {
+		b.WriteString("│")
+		cell := center(ev.Cells[i:i+1], w)
+		if i == ev.Head && tr.color {
+			cell = ansiInverse + cell + ansiReset
+		}
+		b.WriteString(cell)
+	}
+	b.WriteString("│\n")
+	b.WriteString("└" + strings.Repeat(rule+"┴", n-1) + rule + "┘\n")
+	for i := 0; i < n; i++ {
+		b.WriteString(" " + center(strconv.Itoa(i), w))
+	}
+	b.WriteString("\n")
+	if ev.Head >= 0 && ev.Head < n {
+		b.WriteString(strings.Repeat(" ", 1+ev.Head*(w+1)) + center("↑", w))
+	}
+	b.WriteString("\n")
+
+	if tr.inPlace && tr.drawn > 0 {
+		// back to the first line of the previous box, then clear below
+		fmt.Fprintf(tr.w, "\x1b[%dF\x1b[J", tr.drawn)
+	}
+	out := b.String()
+	fmt.Fprint(tr.w, out)
+	tr.drawn = strings.Count(out, "\n")
+}
+
+// center pads s with spaces to width w (s is counted in runes).
+func center(s string, w int) string {
+	pad := w - utf8.RuneCountInString(s)
+	if pad <= 0 {
+		return s
+	}
+	return strings.Repeat(" ", pad/2) + s + strings.Repeat(" ", pad-pad/2)
+}
+
+// prompt writes s and leaves the cursor after it for the user's answer.
+func (tr *tracer) prompt(s string) {
+	fmt.Fprint(tr.w, s)
+	if tr.box && tr.inPlace {
+		tr.drawn++ // the answer's newline ends the prompt line
+	}
+}
+
+// note writes a one-line message between steps.
+func (tr *tracer) note(s string) {
+	if tr.quiet || tr.enc != nil {
+		return
+	}
+	fmt.Fprintln(tr.w, s)
+	if tr.box && tr.inPlace {
+		tr.drawn++
+	}
+}
+
+// verdict renders the final ACCEPT/REJECT word; a run the user stopped
+// has no verdict.
+func (tr *tracer) verdict(res machine.Result) string {
+	switch res.Outcome {
+	case machine.Accepted:
+		return tr.paintStatus(machine.Accept, "ACCEPT")
+	case machine.Stopped:
+		return "STOPPED"
+	}
+	return tr.paintStatus(machine.Reject, "REJECT")
+}
+
+func (tr *tracer) paintStatus(st machine.StepStatus, s string) string {
+	switch {
+	case !tr.color:
+		return s
+	case st == machine.Accept:
+		return ansiGreen + s + ansiReset
+	case st == machine.Reject:
+		return ansiRed + s + ansiReset
+	}
+	return s
+}
+
+func (tr *tracer) tapeView(tape string, head int) string {
+	if !tr.color || head < 0 || head >= len(tape) {
+		return machine.HighlightIndex(tape, head)
+	}
+	return tape[:head] + ansiInverse + tape[head:head+1] + ansiReset + tape[head+1:]
+}
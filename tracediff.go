@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"project_twa/pkg/machine"
+)
+
+// sameStep reports whether two recorded steps did the same thing: from the
+// same state and head, on the same symbol, to the same state and head. The
+// rules-file line is left out, as an edit that shifts lines does not
+// change what the run does.
+func sameStep(a, b machine.StepEvent) bool {
+	a.Step, b.Step = 0, 0
+	a.Line, b.Line = 0, 0
+	a.Cells, b.Cells = "", ""
+	return a == b
+}
+
+// tracediffCmd lines up two recorded runs, typically of a machine before
+// and after an edit, and reports the first step where they part, and where
+// they come back together if they do.
+func tracediffCmd(args []string) {
+	fs := flag.NewFlagSet("tracediff", flag.ContinueOnError)
+	before := fs.Int("context", 3, "steps both traces share to print before the first difference")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 2 {
+		fmt.Println("Usage: go run . tracediff [flags] <run1.jsonl> <run2.jsonl>")
+		fmt.Println("(traces written with --record, or --trace-format json --log)")
+		fs.PrintDefaults()
+		return
+	}
+	var runs [2][]machine.StepEvent
+	for k, path := range args {
+		err := readSteps(path, func(ev machine.StepEvent) bool {
+			runs[k] = append(runs[k], ev)
+			return true
+		})
+		if err != nil {
+			fmt.Println("trace error:", err)
+			os.Exit(2)
+		}
+	}
+	a, b := runs[0], runs[1]
+
+	n := 0 // steps both traces share
+	for n < len(a) && n < len(b) && sameStep(a[n], b[n]) {
+		n++
+	}
+	if n == len(a) && n == len(b) {
+		fmt.Printf("the traces agree: %d steps\n", n)
+		return
+	}
+	fmt.Printf("the traces agree on the first %d steps\n", n)
+	for _, ev := range a[max(0, n-*before):n] {
+		fmt.Println(" ", stepRow(ev))
+	}
+	switch {
+	case n == len(a):
+		fmt.Printf("%s ends there; %s goes on for %d more steps:\n", args[0], args[1], len(b)-n)
+		fmt.Println("+", stepRow(b[n]))
+	case n == len(b):
+		fmt.Printf("%s ends there; %s goes on for %d more steps:\n", args[1], args[0], len(a)-n)
+		fmt.Println("-", stepRow(a[n]))
+	default:
+		fmt.Printf("then step %d differs:\n", n+1)
+		fmt.Println("-", stepRow(a[n]), " ", args[0])
+		fmt.Println("+", stepRow(b[n]), " ", args[1])
+
+		// the first configuration after the split that both runs reach,
+		// if any: from there they go on alike, or loop
+		at := map[[2]int]int{}
+		for j := len(b) - 1; j >= n; j-- {
+			at[[2]int{b[j].Next, b[j].NewHead}] = j
+		}
+		rejoined := false
+		for i := n; i < len(a) && !rejoined; i++ {
+			if j, ok := at[[2]int{a[i].Next, a[i].NewHead}]; ok {
+				fmt.Printf("they meet again in state %d at head %d: after step %d of %s and step %d of %s\n",
+					a[i].Next, a[i].NewHead, i+1, args[0], j+1, args[1])
+				rejoined = true
+			}
+		}
+		if !rejoined {
+			fmt.Println("they do not meet again")
+		}
+	}
+	fmt.Printf("%s: %d steps, ending %s; %s: %d steps, ending %s\n",
+		args[0], len(a), lastStatus(a), args[1], len(b), lastStatus(b))
+	os.Exit(1)
+}
+
+// lastStatus is how a recorded run's last step went.
+func lastStatus(steps []machine.StepEvent) string {
+	if len(steps) == 0 {
+		return "before any step"
+	}
+	return steps[len(steps)-1].Status.String()
+}
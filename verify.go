@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"project_twa/pkg/machine"
+)
+
+// verifyHaltsCmd runs the machine on every input up to --max-len and
+// reports the ones that loop or exceed the step bound.
+func verifyHaltsCmd(args []string) {
+	fs := flag.NewFlagSet("verify-halts", flag.ContinueOnError)
+	alphabet := fs.String("alphabet", "", "input symbols to enumerate (default: the symbols the rules read)")
+	maxLen := fs.Int("max-len", 8, "longest input to try")
+	maxSteps := fs.Int("max-steps", 10000, "step bound each input must halt within")
+	strict := fs.Bool("strict", false, "treat tolerated sloppiness in the rules file as errors")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return
+	}
+	if len(args) != 1 || *maxLen < 0 {
+		fmt.Println("Usage: go run . verify-halts [flags] <rules.txt>")
+		fs.PrintDefaults()
+		return
+	}
+
+	m, err := load(args[0], *strict, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	alpha := *alphabet
+	if alpha == "" {
+		alpha, _ = m.Alphabet()
+	}
+	if alpha == "" || strings.Contains(alpha, "#") {
+		fmt.Println("alphabet error: need at least one input symbol, and '#' is the endmarker")
+		return
+	}
+
+	cfg := machine.Runtime{MaxSteps: *maxSteps}
+	counts := map[machine.Outcome]int{}
+	total, failed := 0, 0
+	machine.ForEachWord(alpha, *maxLen, func(w string) bool {
+		res := runSilent("#"+w+"#", m, cfg)
+		total++
+		counts[res.Outcome]++
+		if res.Outcome == machine.Looped || res.Outcome == machine.StepLimit {
+			failed++
+			fmt.Printf("does not halt: %q  (%s)\n", w, res.Reason)
+		}
+		return true
+	})
+
+	fmt.Printf("checked %d inputs over {%s} up to length %d\n", total, strings.Join(strings.Split(alpha, ""), ","), *maxLen)
+	var parts []string
+	for o := machine.Accepted; o <= machine.TimedOut; o++ {
+		if counts[o] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", o, counts[o]))
+		}
+	}
+	fmt.Println(strings.Join(parts, ", "))
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d inputs do not halt\n", failed, total)
+		os.Exit(1)
+	}
+	fmt.Printf("OK: every input halts within %d steps\n", *maxSteps)
+}